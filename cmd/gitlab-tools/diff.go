@@ -0,0 +1,42 @@
+package main
+
+import "github.com/reflexias/gitlab-tools/pkg/pipeline"
+
+// diff exits with exitProblems when the pipelines differ, like diff(1).
+func diff(args []string) int {
+	set := flags("diff")
+	includes := set.Bool("includes", false, "merge included files in")
+	asJSON := set.Bool("json", false, "print JSON instead of text")
+	if err := set.Parse(args); err != nil || set.NArg() != 2 {
+		set.Usage()
+		return exitFailure
+	}
+
+	a, err := load(set.Arg(0), *includes, nil)
+	if err != nil {
+		return fail(err)
+	}
+	b, err := load(set.Arg(1), *includes, nil)
+	if err != nil {
+		return fail(err)
+	}
+
+	d := pipeline.Diff(a, b)
+	if *asJSON {
+		out, err := d.JSON()
+		if err != nil {
+			return fail(err)
+		}
+		err = output("", string(out)+"\n")
+	} else {
+		err = output("", d.String())
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	if !d.Empty() {
+		return exitProblems
+	}
+	return exitOK
+}
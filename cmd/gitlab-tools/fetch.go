@@ -0,0 +1,37 @@
+package main
+
+import "github.com/reflexias/gitlab-tools/pkg/getfile"
+
+// fetch prints a file from a GitLab repository, or from a local directory or
+// git repository with -backend. The token is read from GITLAB_TOKEN, inside
+// GitLab CI CI_JOB_TOKEN is used when it is not set.
+func fetch(args []string) int {
+	set := flags("fetch")
+	config := getfile.ConfigFromEnv()
+	set.StringVar(&config.Backend, "backend", config.Backend, "gitlab, local or git, gitlab when empty")
+	set.StringVar(&config.Dir, "dir", config.Dir, "project `DIR` for the local and git backends")
+	set.StringVar(&config.BaseURL, "server", config.BaseURL, "GitLab `URL` or host, gitlab.com or CI_SERVER_URL when empty")
+	set.StringVar(&config.TokenType, "token-type", config.TokenType, "GITLAB_TOKEN is a private, job or oauth token")
+	set.StringVar(&config.CacheDir, "cache", config.CacheDir, "cache files in `DIR`")
+	set.BoolVar(&config.Offline, "offline", config.Offline, "only read from the cache")
+	ref := set.String("ref", "", "branch, tag or commit, the default branch when empty")
+	out := set.String("o", "", "write to `FILE` instead of stdout")
+	if err := set.Parse(args); err != nil || set.NArg() != 2 {
+		set.Usage()
+		return exitFailure
+	}
+
+	src, err := getfile.New(config)
+	if err != nil {
+		return fail(err)
+	}
+
+	data, err := src.File(set.Arg(0), set.Arg(1), *ref)
+	if err != nil {
+		return fail(err)
+	}
+	if err := output(*out, string(data)); err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
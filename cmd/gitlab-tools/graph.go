@@ -0,0 +1,31 @@
+package main
+
+import "fmt"
+
+func graph(args []string) int {
+	set := flags("graph")
+	includes := set.Bool("includes", false, "merge included files in")
+	format := set.String("format", "dot", "dot or mermaid")
+	if err := set.Parse(args); err != nil || set.NArg() != 1 {
+		set.Usage()
+		return exitFailure
+	}
+
+	p, err := load(set.Arg(0), *includes, nil)
+	if err != nil {
+		return fail(err)
+	}
+
+	switch *format {
+	case "dot":
+		err = output("", p.DOT())
+	case "mermaid":
+		err = output("", p.Mermaid())
+	default:
+		err = fmt.Errorf("unknown format %q, use dot or mermaid", *format)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
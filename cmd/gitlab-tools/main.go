@@ -0,0 +1,222 @@
+// Command gitlab-tools renders, validates, draws, compares and simulates
+// GitLab CI pipelines, and fetches files from GitLab repositories.
+//
+// Pipelines are read from a YAML file, from stdin with -, or from a Go
+// generator: a .go file or a directory holding a main package, which is run
+// with go run and must print the pipeline to stdout.
+//
+// Exit codes: 0 on success, 1 when problems or differences were found and 2
+// when the command could not run.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/reflexias/gitlab-tools/pkg/getfile"
+	"github.com/reflexias/gitlab-tools/pkg/pipeline"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	exitOK       = 0
+	exitProblems = 1
+	exitFailure  = 2
+)
+
+type (
+	command struct {
+		usage string
+		run   func(args []string) int
+	}
+	// stringsFlag collects a flag given more than once.
+	stringsFlag []string
+)
+
+// commands is filled in init, the commands refer to it for their usage.
+var commands map[string]*command
+
+func init() {
+	commands = map[string]*command{
+		"render":   {"render [-includes] [-deterministic] [-o FILE] PIPELINE", render},
+		"validate": {"validate [-includes] PIPELINE...", validate},
+		"graph":    {"graph [-includes] [-format dot|mermaid] PIPELINE", graph},
+		"diff":     {"diff [-includes] [-json] OLD NEW", diff},
+		"simulate": {"simulate [-includes] [-var NAME=VALUE]... [-changed PATH]... [-dir DIR] [-json] PIPELINE", simulate},
+		"fetch":    {"fetch [-backend gitlab|local|git] [-dir DIR] [-server URL] [-token-type private|job|oauth] [-ref REF] [-cache DIR [-offline]] [-o FILE] PROJECT PATH", fetch},
+	}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	logger := logrus.New()
+	logger.SetOutput(os.Stderr)
+	pipeline.SetLogger(logger)
+	getfile.SetLogger(logger)
+
+	if len(args) > 0 && (args[0] == "-debug" || args[0] == "--debug") {
+		logger.SetLevel(logrus.DebugLevel)
+		args = args[1:]
+	}
+
+	if len(args) == 0 {
+		usage()
+		return exitFailure
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		usage()
+		return exitFailure
+	}
+	return cmd.run(args[1:])
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: gitlab-tools [-debug] COMMAND [ARGS]")
+	for _, name := range []string{"render", "validate", "graph", "diff", "simulate", "fetch"} {
+		fmt.Fprintln(os.Stderr, "  gitlab-tools "+commands[name].usage)
+	}
+}
+
+// flags returns a flag set for name that reports errors itself.
+func flags(name string) *flag.FlagSet {
+	set := flag.NewFlagSet(name, flag.ContinueOnError)
+	set.Usage = func() {
+		fmt.Fprintln(set.Output(), "usage: gitlab-tools "+commands[name].usage)
+		set.PrintDefaults()
+	}
+	return set
+}
+
+// load reads a pipeline from a YAML file, stdin or a Go generator. With
+// includes set its includes are merged in, include:rules see vars.
+func load(source string, includes bool, vars map[string]string) (*pipeline.Pipeline, error) {
+	var data []byte
+	var err error
+
+	switch {
+	case source == "-":
+		data, err = io.ReadAll(os.Stdin)
+	case isGenerator(source):
+		data, err = generate(source)
+	default:
+		data, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var p *pipeline.Pipeline
+	if includes {
+		var resolver *pipeline.IncludeResolver
+		resolver, err = includeResolver(source)
+		if err == nil {
+			p, err = resolver.SetVariables(vars).Resolve(data)
+		}
+	} else {
+		p, err = pipeline.Parse(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+	if p.Name == "" {
+		p.Name = strings.TrimSuffix(filepath.Base(source), filepath.Ext(source))
+	}
+	return p, nil
+}
+
+// includeResolver reads includes with the getfile backend from the
+// environment, see getfile.ConfigFromEnv, or from the directory of source
+// when none is set. The project and ref are those of the CI job.
+func includeResolver(source string) (*pipeline.IncludeResolver, error) {
+	config := getfile.ConfigFromEnv()
+	if config.Backend == "" && os.Getenv("GITLAB_CI") == "" {
+		config.Backend = getfile.BackendLocal
+		if config.Dir == "" && source != "-" && !isGenerator(source) {
+			config.Dir = filepath.Dir(source)
+		}
+	}
+
+	src, err := getfile.New(config)
+	if err != nil {
+		return nil, err
+	}
+	return pipeline.NewIncludeResolver(src, os.Getenv("CI_PROJECT_PATH"), os.Getenv("CI_COMMIT_SHA")), nil
+}
+
+func isGenerator(source string) bool {
+	if strings.HasSuffix(source, ".go") {
+		return true
+	}
+	info, err := os.Stat(source)
+	if err != nil || !info.IsDir() {
+		return false
+	}
+	matches, _ := filepath.Glob(filepath.Join(source, "*.go"))
+	return len(matches) > 0
+}
+
+// generate runs a Go generator and returns what it printed.
+func generate(source string) ([]byte, error) {
+	stdout := &bytes.Buffer{}
+	cmd := exec.Command("go", "run", source)
+	if info, err := os.Stat(source); err == nil && info.IsDir() {
+		cmd = exec.Command("go", "run", ".")
+		cmd.Dir = source
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("go run %s: %w", source, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// output writes to file, or stdout when file is empty.
+func output(file, content string) error {
+	if file == "" {
+		_, err := io.WriteString(os.Stdout, content)
+		return err
+	}
+	return os.WriteFile(file, []byte(content), 0660)
+}
+
+// fail prints err and returns the exit code for it.
+func fail(err error) int {
+	fmt.Fprintln(os.Stderr, err)
+	return exitFailure
+}
+
+// problems prints every problem in err, one per line.
+func problems(err error) int {
+	errs := pipeline.Errors{}
+	if errors.As(err, &errs) {
+		for _, e := range errs {
+			fmt.Fprintln(os.Stderr, e)
+		}
+	} else {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return exitProblems
+}
+
+func (this *stringsFlag) String() string {
+	return strings.Join(*this, ",")
+}
+
+func (this *stringsFlag) Set(value string) error {
+	*this = append(*this, value)
+	return nil
+}
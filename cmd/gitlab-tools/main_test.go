@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"ok.yml":      "stages: [test]\njob:\n  stage: test\n  script: [echo hi]\n",
+		"changed.yml": "stages: [test]\njob:\n  stage: test\n  script: [echo bye]\n",
+		"needs.yml":   "stages: [test]\njob:\n  stage: test\n  script: [echo hi]\n  needs: [missing]\n",
+		"workflow.yml": "workflow:\n  rules:\n    - if: '$A == ('\n" +
+			"stages: [test]\njob:\n  stage: test\n  script: [echo hi]\n",
+		"rules.yml": "stages: [test]\njob:\n  stage: test\n  script: [echo hi]\n  rules:\n    - if: '$A == ('\n" +
+			"other:\n  stage: test\n  script: [echo hi]\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"no command", nil, exitFailure},
+		{"unknown command", []string{"bogus"}, exitFailure},
+		{"render", []string{"render", file("ok.yml")}, exitOK},
+		{"render to file", []string{"render", "-o", file("out.yml"), file("ok.yml")}, exitOK},
+		{"render missing file", []string{"render", file("missing.yml")}, exitFailure},
+		{"render usage", []string{"render"}, exitFailure},
+		{"validate", []string{"validate", file("ok.yml")}, exitOK},
+		{"validate problems", []string{"validate", file("ok.yml"), file("needs.yml")}, exitProblems},
+		{"validate missing file", []string{"validate", file("needs.yml"), file("missing.yml")}, exitFailure},
+		{"diff same", []string{"diff", file("ok.yml"), file("ok.yml")}, exitOK},
+		{"diff changed", []string{"diff", file("ok.yml"), file("changed.yml")}, exitProblems},
+		{"diff missing file", []string{"diff", file("ok.yml"), file("missing.yml")}, exitFailure},
+		{"simulate", []string{"simulate", file("ok.yml")}, exitOK},
+		{"simulate job problems", []string{"simulate", "-json", file("rules.yml")}, exitProblems},
+		{"simulate workflow error", []string{"simulate", file("workflow.yml")}, exitFailure},
+		{"simulate bad var", []string{"simulate", "-var", "A", file("ok.yml")}, exitFailure},
+		{"fetch", []string{"fetch", "-backend", "local", "-dir", dir, "group/project", "ok.yml"}, exitOK},
+		{"fetch missing file", []string{"fetch", "-backend", "local", "-dir", dir, "group/project", "missing.yml"}, exitFailure},
+		{"fetch unknown backend", []string{"fetch", "-backend", "bogus", "group/project", "ok.yml"}, exitFailure},
+	}
+
+	stdout := os.Stdout
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	os.Stdout = devNull
+	defer func() { os.Stdout = stdout }()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := run(test.args); got != test.want {
+				t.Errorf("run(%q) = %d, want %d", test.args, got, test.want)
+			}
+		})
+	}
+}
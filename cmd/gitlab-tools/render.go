@@ -0,0 +1,29 @@
+package main
+
+// render parses a pipeline and prints it the way the pipeline package renders
+// it, which normalizes hand written files.
+func render(args []string) int {
+	set := flags("render")
+	includes := set.Bool("includes", false, "merge included files in")
+	deterministic := set.Bool("deterministic", false, "derive the pipeline ID from its content")
+	out := set.String("o", "", "write to `FILE` instead of stdout")
+	if err := set.Parse(args); err != nil || set.NArg() != 1 {
+		set.Usage()
+		return exitFailure
+	}
+
+	p, err := load(set.Arg(0), *includes, nil)
+	if err != nil {
+		return fail(err)
+	}
+	p.SetDeterministic(*deterministic)
+
+	rendered, err := p.RenderE()
+	if err != nil {
+		return problems(err)
+	}
+	if err := output(*out, rendered); err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
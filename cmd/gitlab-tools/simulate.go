@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// simulate prints the jobs a pipeline would get for the given variables and
+// changed files. Without -changed every rules:changes matches.
+func simulate(args []string) int {
+	set := flags("simulate")
+	includes := set.Bool("includes", false, "merge included files in")
+	vars := stringsFlag{}
+	changed := stringsFlag{}
+	set.Var(&vars, "var", "CI variable as `NAME=VALUE`, may be repeated")
+	set.Var(&changed, "changed", "changed file `PATH`, may be repeated")
+	dir := set.String("dir", ".", "project `DIR` for rules:exists")
+	asJSON := set.Bool("json", false, "print JSON instead of a table")
+	if err := set.Parse(args); err != nil || set.NArg() != 1 {
+		set.Usage()
+		return exitFailure
+	}
+
+	variables := map[string]string{}
+	for _, v := range vars {
+		name, value, ok := strings.Cut(v, "=")
+		if !ok {
+			return fail(fmt.Errorf("-var %q: expected NAME=VALUE", v))
+		}
+		variables[name] = value
+	}
+	var changedFiles []string
+	if len(changed) > 0 {
+		changedFiles = changed
+	}
+
+	p, err := load(set.Arg(0), *includes, variables)
+	if err != nil {
+		return fail(err)
+	}
+	sim, err := p.SimulateFS(os.DirFS(*dir), variables, changedFiles)
+	if sim == nil {
+		return fail(err)
+	}
+	// Jobs that could not be simulated are reported after the rest.
+	status := exitOK
+	if err != nil {
+		status = problems(err)
+	}
+
+	if *asJSON {
+		type job struct {
+			Name         string `json:"name"`
+			Stage        string `json:"stage"`
+			When         string `json:"when"`
+			AllowFailure bool   `json:"allow_failure"`
+			Reason       string `json:"reason"`
+		}
+		out := struct {
+			Created bool   `json:"created"`
+			Reason  string `json:"reason,omitempty"`
+			Jobs    []job  `json:"jobs"`
+			Skipped []job  `json:"skipped"`
+		}{Created: sim.Created, Reason: sim.Reason, Jobs: []job{}, Skipped: []job{}}
+		for _, j := range sim.Jobs {
+			out.Jobs = append(out.Jobs, job{j.Name, j.Stage, j.When, j.AllowFailure, j.Reason})
+		}
+		for _, j := range sim.Skipped {
+			out.Skipped = append(out.Skipped, job{j.Name, j.Stage, j.When, j.AllowFailure, j.Reason})
+		}
+		data, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return fail(err)
+		}
+		fmt.Println(string(data))
+		return status
+	}
+
+	if !sim.Created {
+		fmt.Println("Pipeline not created: " + sim.Reason)
+		return status
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "STAGE\tJOB\tWHEN\tALLOW FAILURE\tREASON")
+	for _, j := range sim.Jobs {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%v\t%s\n", j.Stage, j.Name, j.When, j.AllowFailure, j.Reason)
+	}
+	for _, j := range sim.Skipped {
+		fmt.Fprintf(table, "%s\t%s\t%s\t-\t%s\n", j.Stage, j.Name, "skipped", j.Reason)
+	}
+	if err := table.Flush(); err != nil {
+		return fail(err)
+	}
+	return status
+}
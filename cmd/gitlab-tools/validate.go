@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/reflexias/gitlab-tools/pkg/pipeline"
+)
+
+// validate checks every pipeline given and reports all problems found, not
+// just those of the first pipeline.
+func validate(args []string) int {
+	set := flags("validate")
+	includes := set.Bool("includes", false, "merge included files in")
+	if err := set.Parse(args); err != nil || set.NArg() == 0 {
+		set.Usage()
+		return exitFailure
+	}
+
+	code := exitOK
+	for _, source := range set.Args() {
+		p, err := load(source, *includes, nil)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = exitFailure
+			continue
+		}
+
+		errs := pipeline.Errors{}
+		if _, err := p.RenderE(); err != nil {
+			errs = append(errs, err.(pipeline.Errors)...)
+		}
+		if err := p.Validate(); err != nil {
+			for _, e := range err.(pipeline.Errors) {
+				// RenderE already reported duplicate jobs.
+				if !errors.Is(e, pipeline.ErrDuplicateJob) {
+					errs = append(errs, e)
+				}
+			}
+		}
+
+		if len(errs) > 0 {
+			fmt.Fprintf(os.Stderr, "%s:\n", source)
+			problems(errs)
+			if code == exitOK {
+				code = exitProblems
+			}
+		}
+	}
+	return code
+}
@@ -3,6 +3,7 @@ module github.com/reflexias/gitlab-tools
 go 1.22.2
 
 require (
+	github.com/BurntSushi/toml v1.4.0
 	github.com/google/uuid v1.6.0
 	github.com/sirupsen/logrus v1.9.3
 	github.com/xanzy/go-gitlab v0.108.0
@@ -0,0 +1,91 @@
+// Package glob matches slash separated paths against the patterns GitLab
+// accepts in rules:changes and rules:exists: * and ? stay within one
+// directory, ** crosses directories, [...] matches a class and {a,b} matches
+// any of the alternatives.
+package glob
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Compile turns pattern into an anchored regular expression.
+func Compile(pattern string) (*regexp.Regexp, error) {
+	out := strings.Builder{}
+	out.WriteString("^")
+
+	braces := 0
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					out.WriteString("(?:.*/)?")
+				} else {
+					out.WriteString(".*")
+				}
+			} else {
+				out.WriteString("[^/]*")
+			}
+		case '?':
+			out.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("glob %q: unterminated [", pattern)
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			out.WriteString("[" + class + "]")
+			i += end + 1
+		case '{':
+			braces++
+			out.WriteString("(?:")
+		case '}':
+			if braces == 0 {
+				return nil, fmt.Errorf("glob %q: unexpected }", pattern)
+			}
+			braces--
+			out.WriteString(")")
+		case ',':
+			if braces > 0 {
+				out.WriteString("|")
+			} else {
+				out.WriteString(",")
+			}
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				out.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			}
+		default:
+			out.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	if braces > 0 {
+		return nil, fmt.Errorf("glob %q: unterminated {", pattern)
+	}
+
+	out.WriteString("$")
+	return regexp.Compile(out.String())
+}
+
+// Match reports whether name matches pattern. Invalid patterns never match.
+func Match(pattern, name string) bool {
+	re, err := Compile(pattern)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(name)
+}
+
+// HasMeta reports whether pattern contains any of the special characters.
+func HasMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[{\`)
+}
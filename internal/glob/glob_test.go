@@ -0,0 +1,72 @@
+package glob
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "cmd/main.go", false},
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "cmd/tools/main.go", true},
+		{"docs/**", "docs/a/b.md", true},
+		{"docs/**", "src/docs/a.md", false},
+		{"?.txt", "a.txt", true},
+		{"?.txt", "ab.txt", false},
+		{"?.txt", "/.txt", false},
+		{"[abc].txt", "b.txt", true},
+		{"[!abc].txt", "b.txt", false},
+		{"[!abc].txt", "d.txt", true},
+		{"*.{yml,yaml}", "ci.yaml", true},
+		{"*.{yml,yaml}", "ci.json", false},
+		{"{src,lib}/**/*.{c,h}", "lib/x/y.h", true},
+		{"a,b", "a,b", true},
+		{`\*.go`, "*.go", true},
+		{`\*.go`, "main.go", false},
+		{"main.go", "main.go", true},
+		{"main.go", "mainxgo", false},
+		{"[abc", "a", false},
+		{"{a,b", "a", false},
+		{"a}", "a}", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pattern+" "+test.name, func(t *testing.T) {
+			if got := Match(test.pattern, test.name); got != test.want {
+				t.Errorf("Match(%q, %q) = %v, want %v", test.pattern, test.name, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, pattern := range []string{"[abc", "{a,b", "a}"} {
+		if _, err := Compile(pattern); err == nil {
+			t.Errorf("Compile(%q) succeeded, want an error", pattern)
+		}
+	}
+}
+
+func TestHasMeta(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    bool
+	}{
+		{"main.go", false},
+		{"cmd/main.go", false},
+		{"*.go", true},
+		{"?.go", true},
+		{"[ab].go", true},
+		{"{a,b}.go", true},
+		{`a\.go`, true},
+	}
+
+	for _, test := range tests {
+		if got := HasMeta(test.pattern); got != test.want {
+			t.Errorf("HasMeta(%q) = %v, want %v", test.pattern, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package getfile
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrNotCached = errors.New("not in cache")
+	ErrBlobSHA   = errors.New("content does not match blob SHA")
+)
+
+// cache stores fetched files on disk. Files are stored once per content under
+// objects/ by their git blob SHA, files/ maps a project, commit and path to
+// that SHA and refs/ holds the commit a ref last resolved to, for offline use.
+// Trees are stored under trees/ by project, commit and path.
+type cache struct {
+	dir string
+}
+
+// WithCache caches files and trees under dir, keyed by the commit their ref
+// resolves to. Every ref is still resolved once per Fetcher, unless offline.
+func WithCache(dir string) Option {
+	return func(o *options) {
+		o.cacheDir = dir
+	}
+}
+
+// WithOffline serves everything from the cache and never calls GitLab, refs
+// resolve to the commit they did last. Requires WithCache.
+func WithOffline() Option {
+	return func(o *options) {
+		o.offline = true
+	}
+}
+
+func (this *cache) ref(project, ref string) (string, error) {
+	data, err := os.ReadFile(this.path("refs", key(project, ref)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("%s:%s: %w", project, ref, ErrNotCached)
+	}
+	return string(data), err
+}
+
+func (this *cache) setRef(project, ref, commit string) error {
+	return this.write(this.path("refs", key(project, ref)), []byte(commit))
+}
+
+// file returns the cached content of path, verified against its blob SHA.
+func (this *cache) file(project, commit, path string) ([]byte, error) {
+	id, err := os.ReadFile(this.path("files", key(project, commit, path)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s:%s/%s: %w", project, commit, path, ErrNotCached)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(this.object(string(id)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s:%s/%s: %w", project, commit, path, ErrNotCached)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if blobSHA(data) != string(id) {
+		return nil, fmt.Errorf("%s:%s/%s: cached %w %s", project, commit, path, ErrBlobSHA, id)
+	}
+	return data, nil
+}
+
+func (this *cache) setFile(project, commit, path, id string, data []byte) error {
+	if err := this.write(this.object(id), data); err != nil {
+		return err
+	}
+	return this.write(this.path("files", key(project, commit, path)), []byte(id))
+}
+
+func (this *cache) tree(project, commit, path string, recursive bool) ([]*TreeEntry, error) {
+	data, err := os.ReadFile(this.path("trees", key(project, commit, path, fmt.Sprint(recursive))))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s:%s/%s: %w", project, commit, path, ErrNotCached)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*TreeEntry{}
+	return entries, json.Unmarshal(data, &entries)
+}
+
+func (this *cache) setTree(project, commit, path string, recursive bool, entries []*TreeEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return this.write(this.path("trees", key(project, commit, path, fmt.Sprint(recursive))), data)
+}
+
+func (this *cache) object(id string) string {
+	if len(id) < 3 {
+		return this.path("objects", id)
+	}
+	return this.path("objects", id[:2], id[2:])
+}
+
+func (this *cache) path(elem ...string) string {
+	return filepath.Join(append([]string{this.dir}, elem...)...)
+}
+
+// write replaces file in one step, so concurrent runs never read half a file.
+func (this *cache) write(file string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+func key(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// blobSHA is the git object ID of data.
+func blobSHA(data []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(data))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// isCommitSHA reports whether ref is a full commit SHA, which never moves.
+func isCommitSHA(ref string) bool {
+	if len(ref) != 40 && len(ref) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(ref)
+	return err == nil
+}
@@ -0,0 +1,75 @@
+package getfile
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const testCommit = "0123456789abcdef0123456789abcdef01234567"
+
+// Files read online are served from the cache offline, by the commit the ref
+// resolved to, and a server that is down is never asked.
+func TestFetcherCache(t *testing.T) {
+	dir := t.TempDir()
+	requests := atomic.Int32{}
+	online := newTestFetcher(t, &gitlabServer{
+		projects: map[string]string{"project": "main"},
+		files:    map[string]string{"project/data.yaml": "a: b\n"},
+		handle: func(w http.ResponseWriter, r *http.Request) bool {
+			requests.Add(1)
+			if strings.HasSuffix(r.URL.Path, "/repository/commits/main") {
+				json.NewEncoder(w).Encode(map[string]string{"id": testCommit})
+				return true
+			}
+			return false
+		},
+	}, WithCache(dir))
+
+	for i := 0; i < 2; i++ {
+		data, err := online.File("project", "data.yaml", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "a: b\n" {
+			t.Fatalf("got %q", data)
+		}
+	}
+	// Default branch, commit and the file, once each.
+	if got := requests.Load(); got != 3 {
+		t.Errorf("got %d requests, want 3", got)
+	}
+
+	down := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("offline fetcher requested %s", r.URL)
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	})
+	offline := newTestFetcher(t, down, WithCache(dir), WithOffline())
+
+	tests := []struct {
+		name    string
+		path    string
+		ref     string
+		want    string
+		wantErr error
+	}{
+		{name: "ref", path: "data.yaml", want: "a: b\n"},
+		{name: "commit", path: "data.yaml", ref: testCommit, want: "a: b\n"},
+		{name: "file not cached", path: "missing.yaml", wantErr: ErrNotCached},
+		{name: "ref not cached", path: "data.yaml", ref: "develop", wantErr: ErrNotCached},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := offline.File("project", test.path, test.ref)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("err = %v, want %v", err, test.wantErr)
+			}
+			if string(data) != test.want {
+				t.Errorf("got %q, want %q", data, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package getfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown file format")
+)
+
+type (
+	// DecodeError is a file that could not be read or decoded, with where
+	// it came from.
+	DecodeError struct {
+		Project string
+		Ref     string
+		Path    string
+		Err     error
+	}
+)
+
+// Decode reads path from project at ref in src and decodes it into a T, as
+// YAML for .yaml and .yml, JSON for .json and TOML for .toml. Keys without a
+// field in T are ignored, see DecodeStrict. Errors reading or decoding the
+// file are a *DecodeError.
+func Decode[T any](src Source, project, path, ref string) (T, error) {
+	return decode[T](src, project, path, ref, false)
+}
+
+// DecodeStrict is Decode with keys that have no field in T being errors, so
+// typos in data files are caught.
+func DecodeStrict[T any](src Source, project, path, ref string) (T, error) {
+	return decode[T](src, project, path, ref, true)
+}
+
+func decode[T any](src Source, project, file, ref string, strict bool) (T, error) {
+	var v T
+
+	data, err := src.File(project, file, ref)
+	if err != nil {
+		return v, &DecodeError{Project: project, Ref: ref, Path: file, Err: err}
+	}
+	if err := Unmarshal(file, data, &v, strict); err != nil {
+		return v, &DecodeError{Project: project, Ref: ref, Path: file, Err: err}
+	}
+	return v, nil
+}
+
+// Unmarshal decodes data into v in the format of file's extension, like
+// Decode does.
+func Unmarshal(file string, data []byte, v any, strict bool) error {
+	switch ext := strings.ToLower(path.Ext(file)); ext {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(strict)
+		if err := decoder.Decode(v); err != nil && err != io.EOF {
+			return err
+		}
+		return nil
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		if strict {
+			decoder.DisallowUnknownFields()
+		}
+		if err := decoder.Decode(v); err != nil {
+			return jsonError(data, err)
+		}
+		if decoder.More() {
+			return errors.New("json: data after the top level value")
+		}
+		return nil
+	case ".toml":
+		meta, err := toml.Decode(string(data), v)
+		if err != nil {
+			return err
+		}
+		if undecoded := meta.Undecoded(); strict && len(undecoded) > 0 {
+			keys := []string{}
+			for _, key := range undecoded {
+				keys = append(keys, key.String())
+			}
+			sort.Strings(keys)
+			return fmt.Errorf("toml: unknown keys %s", strings.Join(keys, ", "))
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w %q, use .yaml, .yml, .json or .toml", ErrUnknownFormat, ext)
+	}
+}
+
+// jsonError adds the line and column to errors that only have an offset.
+func jsonError(data []byte, err error) error {
+	offset := int64(-1)
+	syntax := &json.SyntaxError{}
+	unmarshal := &json.UnmarshalTypeError{}
+	switch {
+	case errors.As(err, &syntax):
+		offset = syntax.Offset
+	case errors.As(err, &unmarshal):
+		offset = unmarshal.Offset
+	}
+	if offset < 0 || offset > int64(len(data)) {
+		return err
+	}
+
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return fmt.Errorf("line %d, column %d: %w", line, column, err)
+}
+
+func (this *DecodeError) Error() string {
+	ref := this.Ref
+	if ref == "" {
+		ref = "(default branch)"
+	}
+	return fmt.Sprintf("%s:%s/%s: %v", this.Project, ref, this.Path, this.Err)
+}
+
+func (this *DecodeError) Unwrap() error {
+	return this.Err
+}
@@ -0,0 +1,123 @@
+package getfile
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type testData struct {
+	Name  string   `yaml:"name" json:"name" toml:"name"`
+	Count int      `yaml:"count" json:"count" toml:"count"`
+	Tags  []string `yaml:"tags" json:"tags" toml:"tags"`
+}
+
+func TestDecode(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"data.yaml":    "name: app\ncount: 2\ntags: [a, b]\n",
+		"data.yml":     "name: app\ncount: 2\ntags: [a, b]\n",
+		"data.json":    `{"name": "app", "count": 2, "tags": ["a", "b"]}`,
+		"data.toml":    "name = \"app\"\ncount = 2\ntags = [\"a\", \"b\"]\n",
+		"DATA.YAML":    "name: app\ncount: 2\ntags: [a, b]\n",
+		"empty.yaml":   "",
+		"extra.yaml":   "name: app\ncount: 2\ntags: [a, b]\nnmae: typo\n",
+		"extra.json":   `{"name": "app", "count": 2, "tags": ["a", "b"], "nmae": "typo"}`,
+		"extra.toml":   "name = \"app\"\ncount = 2\ntags = [\"a\", \"b\"]\nnmae = \"typo\"\n",
+		"invalid.json": "{\n  \"name\": \"app\",\n  \"count\": \"two\"\n}",
+		"twice.json":   `{"name": "app"} {"name": "app"}`,
+		"data.txt":     "name: app\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src := NewLocal(dir)
+	want := testData{Name: "app", Count: 2, Tags: []string{"a", "b"}}
+
+	tests := []struct {
+		path    string
+		strict  bool
+		want    testData
+		wantErr bool
+	}{
+		{path: "data.yaml", want: want},
+		{path: "data.yml", want: want},
+		{path: "data.json", want: want},
+		{path: "data.toml", want: want},
+		{path: "DATA.YAML", want: want},
+		{path: "empty.yaml"},
+		{path: "data.yaml", strict: true, want: want},
+		{path: "extra.yaml", want: want},
+		{path: "extra.json", want: want},
+		{path: "extra.toml", want: want},
+		{path: "extra.yaml", strict: true, wantErr: true},
+		{path: "extra.json", strict: true, wantErr: true},
+		{path: "extra.toml", strict: true, wantErr: true},
+		{path: "invalid.json", wantErr: true},
+		{path: "twice.json", wantErr: true},
+		{path: "data.txt", wantErr: true},
+	}
+
+	for _, test := range tests {
+		name := test.path
+		if test.strict {
+			name += " strict"
+		}
+		t.Run(name, func(t *testing.T) {
+			decode := Decode[testData]
+			if test.strict {
+				decode = DecodeStrict[testData]
+			}
+
+			got, err := decode(src, "project", test.path, "main")
+			if (err != nil) != test.wantErr {
+				t.Fatalf("err = %v, want error %v", err, test.wantErr)
+			}
+			if err != nil {
+				decodeErr := &DecodeError{}
+				if !errors.As(err, &decodeErr) || decodeErr.Path != test.path || decodeErr.Project != "project" {
+					t.Errorf("err = %#v, want a *DecodeError for project/%s", err, test.path)
+				}
+				return
+			}
+			if got.Name != test.want.Name || got.Count != test.want.Count || len(got.Tags) != len(test.want.Tags) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "invalid.json"), []byte("{\n  \"count\": \"two\"\n}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := NewLocal(dir)
+
+	_, err := Decode[testData](src, "project", "invalid.json", "")
+	if want := "project:(default branch)/invalid.json: line 2, "; err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("err = %v, want it to start with %q", err, want)
+	}
+
+	_, err = Decode[testData](src, "project", "missing.yaml", "")
+	if decodeErr := (&DecodeError{}); !errors.As(err, &decodeErr) || decodeErr.Path != "missing.yaml" {
+		t.Errorf("err = %#v, want a *DecodeError for project/missing.yaml", err)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("err = %v, want it to wrap %v", err, fs.ErrNotExist)
+	}
+
+	_, err = Decode[testData](src, "project", "../outside.yaml", "")
+	if err == nil {
+		t.Error("read a file outside the directory")
+	}
+
+	if err := Unmarshal("data.ini", nil, &testData{}, false); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("err = %v, want %v", err, ErrUnknownFormat)
+	}
+}
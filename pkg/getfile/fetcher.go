@@ -0,0 +1,407 @@
+package getfile
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+const (
+	// PrivateToken is a personal, project or group access token, sent as
+	// PRIVATE-TOKEN.
+	PrivateToken TokenType = iota
+	// JobToken is CI_JOB_TOKEN, sent as JOB-TOKEN.
+	JobToken
+	// OAuthToken is an OAuth access token, sent as a bearer token.
+	OAuthToken
+)
+
+type (
+	TokenType int
+	// Fetcher reads files from GitLab repositories with one client, so the
+	// connection is reused across calls. It is safe for concurrent use.
+	Fetcher struct {
+		client    *gitlab.Client
+		tokenType TokenType
+		timeout   time.Duration
+		// Files fetched at once by Files and Glob.
+		concurrency int
+		cache       *cache
+		offline     bool
+
+		mu sync.Mutex
+		// Default branch of every project asked for without a ref.
+		defaultRefs map[string]string
+		// Commit every project and ref resolved to, when caching.
+		commits map[string]string
+	}
+	Option  func(*options)
+	options struct {
+		baseURL     string
+		token       string
+		tokenType   TokenType
+		httpClient  *http.Client
+		retries     *int
+		waitMin     time.Duration
+		waitMax     time.Duration
+		timeout     time.Duration
+		cacheDir    string
+		offline     bool
+		concurrency int
+	}
+	// TreeEntry is a file (blob) or directory (tree) in a repository, ID is
+	// the git object ID.
+	TreeEntry struct {
+		Path string
+		Type string
+		ID   string
+	}
+)
+
+// WithBaseURL sets the GitLab server, gitlab.com by default. A server without
+// a scheme gets https://.
+func WithBaseURL(url string) Option {
+	return func(o *options) {
+		o.baseURL = url
+	}
+}
+
+func WithToken(token string, tokenType TokenType) Option {
+	return func(o *options) {
+		o.token = token
+		o.tokenType = tokenType
+	}
+}
+
+// WithHTTPClient sets the client used for requests, for proxies, custom CAs
+// or transport level timeouts.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = client
+	}
+}
+
+// WithRetries retries failed requests up to max times, waiting between
+// waitMin and waitMax. Zero waits keep the client defaults, a max of zero
+// disables retries.
+func WithRetries(max int, waitMin, waitMax time.Duration) Option {
+	return func(o *options) {
+		o.retries = &max
+		o.waitMin = waitMin
+		o.waitMax = waitMax
+	}
+}
+
+// WithTimeout limits every call, retries included.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// NewFetcher creates a Fetcher. Without a token it authenticates the way a
+// GitLab CI job can: with CI_JOB_TOKEN, against CI_SERVER_URL when no base URL
+// is set. The job token is never sent to any other server.
+func NewFetcher(opts ...Option) (*Fetcher, error) {
+	o := &options{concurrency: DefaultConcurrency}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.token == "" {
+		o.environment()
+	}
+
+	clientOpts := []gitlab.ClientOptionFunc{}
+	if o.baseURL != "" {
+		clientOpts = append(clientOpts, gitlab.WithBaseURL(baseURL(o.baseURL)))
+	}
+	if o.httpClient != nil {
+		clientOpts = append(clientOpts, gitlab.WithHTTPClient(o.httpClient))
+	}
+	if o.retries != nil {
+		if *o.retries == 0 {
+			clientOpts = append(clientOpts, gitlab.WithoutRetries())
+		} else {
+			clientOpts = append(clientOpts, gitlab.WithCustomRetryMax(*o.retries))
+		}
+	}
+	if o.waitMin > 0 || o.waitMax > 0 {
+		clientOpts = append(clientOpts, gitlab.WithCustomRetryWaitMinMax(o.waitMin, o.waitMax))
+	}
+
+	if o.offline && o.cacheDir == "" {
+		return nil, errors.New("offline mode needs a cache")
+	}
+
+	var client *gitlab.Client
+	var err error
+	switch o.tokenType {
+	case PrivateToken:
+		client, err = gitlab.NewClient(o.token, clientOpts...)
+	case JobToken:
+		client, err = gitlab.NewJobClient(o.token, clientOpts...)
+	case OAuthToken:
+		client, err = gitlab.NewOAuthClient(o.token, clientOpts...)
+	default:
+		err = fmt.Errorf("unknown token type %d", o.tokenType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fetcher := &Fetcher{
+		client:      client,
+		tokenType:   o.tokenType,
+		timeout:     o.timeout,
+		offline:     o.offline,
+		concurrency: max(o.concurrency, 1),
+		defaultRefs: map[string]string{},
+		commits:     map[string]string{},
+	}
+	if o.cacheDir != "" {
+		fetcher.cache = &cache{dir: o.cacheDir}
+	}
+	return fetcher, nil
+}
+
+// environment fills in the job token and server of the running CI job.
+func (this *options) environment() {
+	token, server := os.Getenv("CI_JOB_TOKEN"), os.Getenv("CI_SERVER_URL")
+	if token == "" || server == "" {
+		return
+	}
+	if this.baseURL == "" {
+		this.baseURL = server
+	}
+	if strings.TrimSuffix(baseURL(this.baseURL), "/") != strings.TrimSuffix(server, "/") {
+		log.Debug("Not using CI_JOB_TOKEN for ", this.baseURL, ", it is only valid for ", server)
+		return
+	}
+	log.Debug("Using CI_JOB_TOKEN for ", server)
+	this.token = token
+	this.tokenType = JobToken
+}
+
+// File returns the content of path in project at ref, the default branch
+// when ref is empty. The content is checked against its blob SHA.
+func (this *Fetcher) File(project, path, ref string) ([]byte, error) {
+	ctx, cancel := this.context()
+	defer cancel()
+
+	ref, err := this.resolve(ctx, project, ref)
+	if err != nil {
+		return nil, err
+	}
+	if this.cache != nil {
+		data, err := this.cache.file(project, ref, path)
+		if err == nil || this.offline {
+			return data, err
+		}
+		log.Debug(err)
+	}
+
+	log.Debugf("retrieving file %s from %s:%s", path, project, ref)
+	f, _, err := this.client.RepositoryFiles.GetFile(project, path, &gitlab.GetFileOptions{
+		Ref: gitlab.Ptr(ref),
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s/%s: %w", project, ref, path, err)
+	}
+
+	data := []byte(f.Content)
+	if f.Encoding == "base64" {
+		data, err = base64.StdEncoding.DecodeString(f.Content)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%s/%s: %w", project, ref, path, err)
+		}
+	}
+	if f.BlobID != "" && blobSHA(data) != f.BlobID {
+		return nil, fmt.Errorf("%s:%s/%s: %w %s", project, ref, path, ErrBlobSHA, f.BlobID)
+	}
+
+	if this.cache != nil && f.BlobID != "" {
+		if err := this.cache.setFile(project, ref, path, f.BlobID, data); err != nil {
+			log.Warn("Can't cache ", path, ": ", err)
+		}
+	}
+	return data, nil
+}
+
+// Files returns the content of every path, keyed by path, fetching several
+// at once. It fails when any file can't be read.
+func (this *Fetcher) Files(project, ref string, paths ...string) (map[string][]byte, error) {
+	// Resolve once for all files, with a cache that pins them to one commit.
+	ctx, cancel := this.context()
+	defer cancel()
+	ref, err := this.resolve(ctx, project, ref)
+	if err != nil {
+		return nil, err
+	}
+	return this.fetchAll(project, ref, paths)
+}
+
+// Tree lists the entries under path in project at ref, the whole repository
+// when path is empty, descending into directories when recursive is set.
+func (this *Fetcher) Tree(project, path, ref string, recursive bool) ([]*TreeEntry, error) {
+	ctx, cancel := this.context()
+	defer cancel()
+
+	ref, err := this.resolve(ctx, project, ref)
+	if err != nil {
+		return nil, err
+	}
+	if this.cache != nil {
+		entries, err := this.cache.tree(project, ref, path, recursive)
+		if err == nil || this.offline {
+			return entries, err
+		}
+		log.Debug(err)
+	}
+
+	opts := &gitlab.ListTreeOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 100},
+		Ref:         gitlab.Ptr(ref),
+		Recursive:   gitlab.Ptr(recursive),
+	}
+	if path != "" {
+		opts.Path = gitlab.Ptr(path)
+	}
+
+	entries := []*TreeEntry{}
+	for {
+		nodes, resp, err := this.client.Repositories.ListTree(project, opts, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%s/%s: %w", project, ref, path, err)
+		}
+		for _, node := range nodes {
+			entries = append(entries, &TreeEntry{Path: node.Path, Type: node.Type, ID: node.ID})
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	if this.cache != nil {
+		if err := this.cache.setTree(project, ref, path, recursive, entries); err != nil {
+			log.Warn("Can't cache tree ", path, ": ", err)
+		}
+	}
+	return entries, nil
+}
+
+// resolve returns the ref to read from: the commit ref points to when
+// caching, so cached content never goes stale, otherwise ref or the default
+// branch.
+func (this *Fetcher) resolve(ctx context.Context, project, ref string) (string, error) {
+	if this.cache == nil {
+		return this.ref(ctx, project, ref)
+	}
+	if isCommitSHA(ref) {
+		return ref, nil
+	}
+
+	this.mu.Lock()
+	commit, ok := this.commits[project+"\x00"+ref]
+	this.mu.Unlock()
+	if ok {
+		return commit, nil
+	}
+
+	if this.offline {
+		commit, err := this.cache.ref(project, ref)
+		if err != nil {
+			return "", err
+		}
+		log.Debug("Offline, using ", commit, " for ", project, ":", ref)
+		return commit, nil
+	}
+
+	branch, err := this.ref(ctx, project, ref)
+	if err != nil {
+		return "", err
+	}
+	c, _, err := this.client.Commits.GetCommit(project, branch, nil, gitlab.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("%s:%s: %w", project, branch, err)
+	}
+	log.Debug("Resolved ", project, ":", branch, " to ", c.ID)
+
+	this.mu.Lock()
+	this.commits[project+"\x00"+ref] = c.ID
+	this.mu.Unlock()
+	if err := this.cache.setRef(project, ref, c.ID); err != nil {
+		log.Warn("Can't cache ref ", ref, ": ", err)
+	}
+	return c.ID, nil
+}
+
+// ref returns ref, or the default branch of project when it is empty.
+func (this *Fetcher) ref(ctx context.Context, project, ref string) (string, error) {
+	if ref != "" {
+		return ref, nil
+	}
+
+	this.mu.Lock()
+	ref, ok := this.defaultRefs[project]
+	this.mu.Unlock()
+	if ok {
+		return ref, nil
+	}
+
+	// Not locked during the request, so other fetches don't wait for it.
+	// Fetches racing for the same project may both ask, the answer is the
+	// same.
+	log.Debug("Ref is empty, getting project settings for ", project)
+	p, _, err := this.client.Projects.GetProject(project, &gitlab.GetProjectOptions{}, gitlab.WithContext(ctx))
+	if err != nil && this.tokenType == JobToken && forbidden(err) {
+		// Job tokens may not read project settings, HEAD is the default
+		// branch too. Any other error is real, a missing project or a
+		// failed request, and isn't hidden behind HEAD.
+		log.Debug("Can't read project settings with a job token, using HEAD for: ", project)
+		ref = "HEAD"
+	} else if err != nil {
+		return "", fmt.Errorf("%s: %w", project, err)
+	} else {
+		log.Debug("Setting Ref to: ", p.DefaultBranch, " for: ", project)
+		ref = p.DefaultBranch
+	}
+
+	this.mu.Lock()
+	this.defaultRefs[project] = ref
+	this.mu.Unlock()
+
+	return ref, nil
+}
+
+// forbidden reports whether err is GitLab refusing the token, 401 or 403.
+func forbidden(err error) bool {
+	response := &gitlab.ErrorResponse{}
+	if !errors.As(err, &response) || response.Response == nil {
+		return false
+	}
+	status := response.Response.StatusCode
+	return status == http.StatusUnauthorized || status == http.StatusForbidden
+}
+
+func (this *Fetcher) context() (context.Context, context.CancelFunc) {
+	if this.timeout > 0 {
+		return context.WithTimeout(context.Background(), this.timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+func baseURL(server string) string {
+	if strings.Contains(server, "://") {
+		return server
+	}
+	return "https://" + server
+}
@@ -0,0 +1,197 @@
+package getfile
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gitlabServer fakes the GitLab API: projects maps a project to its default
+// branch, files maps project/path to content. handle can take over any
+// request first by returning true.
+type gitlabServer struct {
+	projects map[string]string
+	files    map[string]string
+	handle   func(w http.ResponseWriter, r *http.Request) bool
+}
+
+func (this *gitlabServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if this.handle != nil && this.handle(w, r) {
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/v4/projects/")
+	project, rest, _ := strings.Cut(path, "/")
+	branch, ok := this.projects[project]
+	if !ok {
+		http.Error(w, `{"message":"404 Project Not Found"}`, http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case rest == "":
+		json.NewEncoder(w).Encode(map[string]string{"default_branch": branch})
+	case strings.HasPrefix(rest, "repository/files/"):
+		content, ok := this.files[project+"/"+strings.TrimPrefix(rest, "repository/files/")]
+		if !ok {
+			http.Error(w, `{"message":"404 File Not Found"}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"content":  base64.StdEncoding.EncodeToString([]byte(content)),
+			"encoding": "base64",
+			"blob_id":  blobSHA([]byte(content)),
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestFetcher(t *testing.T, server http.Handler, opts ...Option) *Fetcher {
+	t.Helper()
+	t.Setenv("CI_JOB_TOKEN", "")
+	t.Setenv("CI_SERVER_URL", "")
+
+	s := httptest.NewServer(server)
+	t.Cleanup(s.Close)
+
+	fetcher, err := NewFetcher(append([]Option{
+		WithBaseURL(s.URL),
+		WithToken("token", PrivateToken),
+		WithRetries(0, 0, 0),
+		WithTimeout(10 * time.Second),
+	}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fetcher
+}
+
+func TestFetcherFile(t *testing.T) {
+	fetcher := newTestFetcher(t, &gitlabServer{
+		projects: map[string]string{"project": "main"},
+		files:    map[string]string{"project/data.yaml": "a: b\n"},
+	})
+
+	tests := []struct {
+		name    string
+		project string
+		path    string
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{name: "default branch", project: "project", path: "data.yaml", want: "a: b\n"},
+		{name: "ref", project: "project", path: "data.yaml", ref: "main", want: "a: b\n"},
+		{name: "missing file", project: "project", path: "missing.yaml", wantErr: true},
+		{name: "missing project", project: "missing", path: "data.yaml", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := fetcher.File(test.project, test.path, test.ref)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("err = %v, want error %v", err, test.wantErr)
+			}
+			if string(data) != test.want {
+				t.Errorf("got %q, want %q", data, test.want)
+			}
+		})
+	}
+}
+
+// Looking up one project's default branch must not hold up fetches from
+// other projects.
+func TestFetcherRefConcurrent(t *testing.T) {
+	release := make(chan struct{})
+	blocked := make(chan struct{})
+	fetcher := newTestFetcher(t, &gitlabServer{
+		projects: map[string]string{"slow": "main", "fast": "main"},
+		files:    map[string]string{"fast/a.txt": "a"},
+		handle: func(w http.ResponseWriter, r *http.Request) bool {
+			if r.URL.Path == "/api/v4/projects/slow" {
+				close(blocked)
+				<-release
+			}
+			return false
+		},
+	})
+
+	go fetcher.File("slow", "a.txt", "")
+	<-blocked
+
+	done := make(chan error)
+	go func() {
+		_, err := fetcher.File("fast", "a.txt", "")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		close(release)
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		close(release)
+		t.Fatal("fetch waited for another project's default branch")
+	}
+}
+
+// With a job token, only a refused project lookup falls back to HEAD, other
+// errors are reported and the lookup is tried again next time.
+func TestFetcherJobTokenDefaultBranch(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		wantRefs []string
+	}{
+		{name: "forbidden", statuses: []int{http.StatusForbidden, http.StatusOK}, wantRefs: []string{"HEAD", "HEAD"}},
+		{name: "unauthorized", statuses: []int{http.StatusUnauthorized}, wantRefs: []string{"HEAD"}},
+		{name: "not found", statuses: []int{http.StatusNotFound}, wantRefs: []string{""}},
+		{name: "server error is not cached", statuses: []int{http.StatusInternalServerError, http.StatusOK}, wantRefs: []string{"", "main"}},
+		{name: "allowed", statuses: []int{http.StatusOK, http.StatusForbidden}, wantRefs: []string{"main", "main"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests := 0
+			server := &gitlabServer{
+				projects: map[string]string{"project": "main"},
+				handle: func(w http.ResponseWriter, r *http.Request) bool {
+					if r.URL.Path != "/api/v4/projects/project" {
+						return false
+					}
+					status := test.statuses[requests]
+					requests++
+					if status == http.StatusOK {
+						return false
+					}
+					http.Error(w, `{"message":"error"}`, status)
+					return true
+				},
+			}
+			fetcher := newTestFetcher(t, server, WithToken("job", JobToken))
+
+			for i, want := range test.wantRefs {
+				ref, err := fetcher.ref(context.Background(), "project", "")
+				if want == "" {
+					if err == nil {
+						t.Fatalf("call %d: got ref %q, want an error", i, ref)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("call %d: %v", i, err)
+				}
+				if ref != want {
+					t.Errorf("call %d: got ref %q, want %q", i, ref, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package getfile
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Git reads files from local git repositories at a ref, without touching the
+// working tree, using the git command. An empty ref is HEAD.
+type Git struct {
+	projects
+}
+
+// NewGit reads every project from the repository in dir, unless AddProject
+// gives it its own.
+func NewGit(dir string) *Git {
+	return &Git{projects{dir: dir, dirs: map[string]string{}}}
+}
+
+func (this *Git) File(project, file, ref string) ([]byte, error) {
+	ref, err := gitRef(ref)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s/%s: %w", project, ref, file, err)
+	}
+	name, err := cleanPath(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s/%s: %w", project, ref, file, err)
+	}
+
+	data, err := this.git(project, "cat-file", "blob", ref+":"+name)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s/%s: %w", project, ref, file, err)
+	}
+	return data, nil
+}
+
+func (this *Git) Files(project, ref string, paths ...string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	for _, path := range paths {
+		data, err := this.File(project, path, ref)
+		if err != nil {
+			return nil, err
+		}
+		files[path] = data
+	}
+	return files, nil
+}
+
+func (this *Git) Tree(project, dir, ref string, recursive bool) ([]*TreeEntry, error) {
+	ref, err := gitRef(ref)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s/%s: %w", project, ref, dir, err)
+	}
+	name, err := cleanPath(dir)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s/%s: %w", project, ref, dir, err)
+	}
+
+	args := []string{"ls-tree", "-z", "--full-tree"}
+	if recursive {
+		args = append(args, "-r", "-t")
+	}
+	args = append(args, ref, "--")
+	if name != "." {
+		args = append(args, name+"/")
+	}
+	out, err := this.git(project, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s/%s: %w", project, ref, dir, err)
+	}
+
+	entries := []*TreeEntry{}
+	for _, line := range strings.Split(string(out), "\x00") {
+		// <mode> SP <type> SP <object> TAB <path>
+		info, path, ok := strings.Cut(line, "\t")
+		fields := strings.Fields(info)
+		if !ok || len(fields) != 3 || fields[1] == "commit" {
+			continue
+		}
+		entries = append(entries, &TreeEntry{Path: path, Type: fields[1], ID: fields[2]})
+	}
+	return entries, nil
+}
+
+func (this *Git) Glob(project, ref string, patterns ...string) (map[string][]byte, error) {
+	ref, err := gitRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	// Pin the ref, so every file comes from the same commit.
+	commit, err := this.git(project, "rev-parse", "--verify", ref+"^{commit}")
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s: %w", project, ref, err)
+	}
+	ref = strings.TrimSpace(string(commit))
+
+	paths, err := match(this, project, ref, patterns)
+	if err != nil {
+		return nil, err
+	}
+	return this.Files(project, ref, paths...)
+}
+
+func (this *Git) git(project string, args ...string) ([]byte, error) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := exec.Command("git", append([]string{"-C", this.root(project)}, args...)...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	log.Debug("Running git ", strings.Join(cmd.Args[1:], " "))
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// gitRef returns HEAD for an empty ref and refuses refs git would read as
+// options.
+func gitRef(ref string) (string, error) {
+	if ref == "" {
+		return "HEAD", nil
+	}
+	if strings.HasPrefix(ref, "-") {
+		return ref, fmt.Errorf("invalid ref")
+	}
+	return ref, nil
+}
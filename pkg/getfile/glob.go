@@ -0,0 +1,129 @@
+package getfile
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/reflexias/gitlab-tools/internal/glob"
+)
+
+// DefaultConcurrency is how many files a Fetcher fetches at once.
+const DefaultConcurrency = 8
+
+// WithConcurrency sets how many files Files and Glob fetch at once.
+func WithConcurrency(n int) Option {
+	return func(o *options) {
+		o.concurrency = n
+	}
+}
+
+// Glob returns the content of every file in project at ref matching any of
+// the patterns, keyed by path. Patterns are the ones rules:exists accepts,
+// services/*/service.yaml or docs/**/*.md, and the tree is only listed below
+// the part of a pattern without wildcards.
+func (this *Fetcher) Glob(project, ref string, patterns ...string) (map[string][]byte, error) {
+	ctx, cancel := this.context()
+	ref, err := this.resolve(ctx, project, ref)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := this.Match(project, ref, patterns...)
+	if err != nil {
+		return nil, err
+	}
+	return this.fetchAll(project, ref, paths)
+}
+
+// Match returns the sorted paths of the files in project at ref matching any
+// of the patterns, without fetching them.
+func (this *Fetcher) Match(project, ref string, patterns ...string) ([]string, error) {
+	return match(this, project, ref, patterns)
+}
+
+// match lists the trees of src the patterns need and returns the sorted paths
+// of the files matching any pattern.
+func match(src Source, project, ref string, patterns []string) ([]string, error) {
+	matched := map[string]bool{}
+	trees := map[string][]*TreeEntry{}
+
+	for _, pattern := range patterns {
+		pattern = strings.TrimPrefix(pattern, "/")
+		re, err := glob.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		dir := staticDir(pattern)
+		entries, ok := trees[dir]
+		if !ok {
+			entries, err = src.Tree(project, dir, ref, true)
+			if err != nil {
+				return nil, err
+			}
+			trees[dir] = entries
+		}
+
+		for _, entry := range entries {
+			if entry.Type == "blob" && re.MatchString(entry.Path) {
+				matched[entry.Path] = true
+			}
+		}
+	}
+
+	paths := []string{}
+	for path := range matched {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// staticDir is the directory of pattern before its first wildcard.
+func staticDir(pattern string) string {
+	dirs := []string{}
+	parts := strings.Split(pattern, "/")
+	for _, part := range parts[:len(parts)-1] {
+		if glob.HasMeta(part) {
+			break
+		}
+		dirs = append(dirs, part)
+	}
+	return strings.Join(dirs, "/")
+}
+
+// fetchAll fetches paths with at most concurrency requests at a time. The
+// error returned is the one of the first path, in the order given, that
+// failed.
+func (this *Fetcher) fetchAll(project, ref string, paths []string) (map[string][]byte, error) {
+	files := make([][]byte, len(paths))
+	errs := make([]error, len(paths))
+
+	work := make(chan int)
+	wg := sync.WaitGroup{}
+	for n := 0; n < this.concurrency && n < len(paths); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				files[i], errs[i] = this.File(project, paths[i], ref)
+			}
+		}()
+	}
+	for i := range paths {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	result := map[string][]byte{}
+	for i, path := range paths {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		result[path] = files[i]
+	}
+	return result, nil
+}
@@ -0,0 +1,201 @@
+package getfile
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// treeServer serves files from a gitlabServer and lists them as a recursive
+// tree, recording the path of every tree requested.
+func treeServer(files map[string]string, handle func(w http.ResponseWriter, r *http.Request) bool) (*gitlabServer, func() []string) {
+	mu := sync.Mutex{}
+	listed := []string{}
+	server := &gitlabServer{
+		projects: map[string]string{"project": "main"},
+		files:    files,
+		handle: func(w http.ResponseWriter, r *http.Request) bool {
+			if handle != nil && handle(w, r) {
+				return true
+			}
+			if r.URL.Path != "/api/v4/projects/project/repository/tree" {
+				return false
+			}
+			dir := r.URL.Query().Get("path")
+			mu.Lock()
+			listed = append(listed, dir)
+			mu.Unlock()
+
+			nodes := []map[string]string{}
+			for name := range files {
+				path := strings.TrimPrefix(name, "project/")
+				if dir == "" || strings.HasPrefix(path, dir+"/") {
+					nodes = append(nodes, map[string]string{"path": path, "type": "blob", "id": blobSHA([]byte(files[name]))})
+				}
+			}
+			json.NewEncoder(w).Encode(nodes)
+			return true
+		},
+	}
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		sort.Strings(listed)
+		return listed
+	}
+}
+
+func TestFetcherMatch(t *testing.T) {
+	files := map[string]string{
+		"project/.gitlab-ci.yml":                  "stages: []\n",
+		"project/services/api/service.yaml":       "name: api\n",
+		"project/services/web/service.yaml":       "name: web\n",
+		"project/services/web/config/service.yml": "name: nested\n",
+		"project/docs/index.md":                   "# Docs\n",
+		"project/docs/guide/setup.md":             "# Setup\n",
+	}
+
+	tests := []struct {
+		name       string
+		patterns   []string
+		want       []string
+		wantListed []string
+	}{
+		{
+			name:       "single level wildcard",
+			patterns:   []string{"services/*/service.yaml"},
+			want:       []string{"services/api/service.yaml", "services/web/service.yaml"},
+			wantListed: []string{"services"},
+		},
+		{
+			name:       "recursive wildcard",
+			patterns:   []string{"docs/**/*.md"},
+			want:       []string{"docs/guide/setup.md", "docs/index.md"},
+			wantListed: []string{"docs"},
+		},
+		{
+			name:       "alternatives",
+			patterns:   []string{"services/**/service.{yaml,yml}"},
+			want:       []string{"services/api/service.yaml", "services/web/config/service.yml", "services/web/service.yaml"},
+			wantListed: []string{"services"},
+		},
+		{
+			name:       "leading slash",
+			patterns:   []string{"/*.yml"},
+			want:       []string{".gitlab-ci.yml"},
+			wantListed: []string{""},
+		},
+		{
+			name:       "trees listed once",
+			patterns:   []string{"docs/*.md", "docs/guide/*.md", "docs/*.md"},
+			want:       []string{"docs/guide/setup.md", "docs/index.md"},
+			wantListed: []string{"docs", "docs/guide"},
+		},
+		{
+			name:       "no match",
+			patterns:   []string{"*.go"},
+			want:       []string{},
+			wantListed: []string{""},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, listed := treeServer(files, nil)
+			fetcher := newTestFetcher(t, server)
+
+			paths, err := fetcher.Match("project", "main", test.patterns...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(paths, ",") != strings.Join(test.want, ",") {
+				t.Errorf("Match = %q, want %q", paths, test.want)
+			}
+			if got := listed(); strings.Join(got, ",") != strings.Join(test.wantListed, ",") {
+				t.Errorf("listed trees %q, want %q", got, test.wantListed)
+			}
+		})
+	}
+}
+
+func TestStaticDir(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"main.go", ""},
+		{"*.go", ""},
+		{"cmd/main.go", "cmd"},
+		{"services/*/service.yaml", "services"},
+		{"docs/guide/**/*.md", "docs/guide"},
+		{"{a,b}/c/*.md", ""},
+		{"a/[bc]/d.md", "a"},
+	}
+
+	for _, test := range tests {
+		if got := staticDir(test.pattern); got != test.want {
+			t.Errorf("staticDir(%q) = %q, want %q", test.pattern, got, test.want)
+		}
+	}
+}
+
+func TestFetcherGlob(t *testing.T) {
+	files := map[string]string{
+		"project/services/api/service.yaml": "name: api\n",
+		"project/services/web/service.yaml": "name: web\n",
+	}
+	server, _ := treeServer(files, nil)
+	fetcher := newTestFetcher(t, server, WithConcurrency(1))
+
+	got, err := fetcher.Glob("project", "main", "services/*/service.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || string(got["services/api/service.yaml"]) != "name: api\n" || string(got["services/web/service.yaml"]) != "name: web\n" {
+		t.Errorf("Glob = %q", got)
+	}
+}
+
+// The error returned is the one of the first path that failed, however the
+// fetches were scheduled.
+func TestFetcherGlobErrors(t *testing.T) {
+	files := map[string]string{
+		"project/a/1.yaml": "a\n",
+		"project/a/2.yaml": "b\n",
+		"project/a/3.yaml": "c\n",
+		"project/a/4.yaml": "d\n",
+	}
+	server, _ := treeServer(files, func(w http.ResponseWriter, r *http.Request) bool {
+		if strings.HasSuffix(r.URL.Path, "/2.yaml") || strings.HasSuffix(r.URL.Path, "/4.yaml") {
+			http.Error(w, `{"message":"500 Internal Server Error"}`, http.StatusInternalServerError)
+			return true
+		}
+		return false
+	})
+
+	for _, concurrency := range []int{1, 4} {
+		fetcher := newTestFetcher(t, server, WithConcurrency(concurrency))
+		got, err := fetcher.Glob("project", "main", "a/*.yaml")
+		if err == nil {
+			t.Fatalf("concurrency %d: Glob = %q, want an error", concurrency, got)
+		}
+		if !strings.Contains(err.Error(), "a/2.yaml") {
+			t.Errorf("concurrency %d: err = %v, want the error for a/2.yaml", concurrency, err)
+		}
+		if got != nil {
+			t.Errorf("concurrency %d: Glob = %q, want nil", concurrency, got)
+		}
+	}
+
+	// A tree that can't be listed fails Match.
+	fetcher := newTestFetcher(t, server)
+	if _, err := fetcher.Match("missing", "main", "a/*.yaml"); err == nil {
+		t.Error("Match on a missing project succeeded")
+	}
+	if _, err := fetcher.Match("project", "main", "a/[b"); err == nil {
+		t.Error("Match with an invalid pattern succeeded")
+	}
+}
@@ -0,0 +1,129 @@
+package getfile
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+type (
+	// projects maps projects to local directories.
+	projects struct {
+		dir  string
+		dirs map[string]string
+	}
+	// Local reads files from directories on disk, such as the checkout the
+	// generator runs in. Refs are ignored, files are read as they are.
+	Local struct {
+		projects
+	}
+)
+
+// NewLocal reads every project from dir, unless AddProject gives it its own.
+func NewLocal(dir string) *Local {
+	return &Local{projects{dir: dir, dirs: map[string]string{}}}
+}
+
+// AddProject reads project from dir.
+func (this *projects) AddProject(project, dir string) {
+	this.dirs[project] = dir
+}
+
+func (this *projects) root(project string) string {
+	if dir, ok := this.dirs[project]; ok {
+		return dir
+	}
+	if this.dir == "" {
+		return "."
+	}
+	return this.dir
+}
+
+func (this *Local) File(project, file, ref string) ([]byte, error) {
+	name, err := cleanPath(file)
+	if err == nil {
+		var data []byte
+		data, err = fs.ReadFile(os.DirFS(this.root(project)), name)
+		if err == nil {
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("%s/%s: %w", project, file, err)
+}
+
+func (this *Local) Files(project, ref string, paths ...string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	for _, path := range paths {
+		data, err := this.File(project, path, ref)
+		if err != nil {
+			return nil, err
+		}
+		files[path] = data
+	}
+	return files, nil
+}
+
+// Tree lists path like GitLab does, leaving out .git. IDs are left empty.
+func (this *Local) Tree(project, dir, ref string, recursive bool) ([]*TreeEntry, error) {
+	name, err := cleanPath(dir)
+	if err != nil {
+		return nil, fmt.Errorf("%s/%s: %w", project, dir, err)
+	}
+	fsys := os.DirFS(this.root(project))
+
+	entries := []*TreeEntry{}
+	err = fs.WalkDir(fsys, name, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == name {
+			return nil
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return fs.SkipDir
+		}
+
+		entry := &TreeEntry{Path: p, Type: "blob"}
+		if d.IsDir() {
+			entry.Type = "tree"
+		}
+		entries = append(entries, entry)
+
+		if d.IsDir() && !recursive {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s/%s: %w", project, dir, err)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, nil
+}
+
+func (this *Local) Glob(project, ref string, patterns ...string) (map[string][]byte, error) {
+	paths, err := match(this, project, ref, patterns)
+	if err != nil {
+		return nil, err
+	}
+	return this.Files(project, ref, paths...)
+}
+
+// cleanPath turns a repository path into an fs path, refusing paths that
+// leave the repository.
+func cleanPath(p string) (string, error) {
+	p = path.Clean("/" + strings.TrimPrefix(p, "./"))
+	p = strings.TrimPrefix(p, "/")
+	if p == "" {
+		p = "."
+	}
+	if !fs.ValidPath(p) {
+		return "", errors.New("invalid path")
+	}
+	return p, nil
+}
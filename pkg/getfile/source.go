@@ -0,0 +1,113 @@
+package getfile
+
+import (
+	"fmt"
+	"os"
+)
+
+const (
+	BackendGitLab = "gitlab"
+	BackendLocal  = "local"
+	BackendGit    = "git"
+)
+
+type (
+	// Source is where files come from: GitLab with a Fetcher, a directory
+	// with Local or a git repository with Git, so generators can run the
+	// same code online and offline.
+	Source interface {
+		// File returns the content of path in project at ref, the default
+		// branch when ref is empty.
+		File(project, path, ref string) ([]byte, error)
+		// Files returns the content of every path, keyed by path.
+		Files(project, ref string, paths ...string) (map[string][]byte, error)
+		// Tree lists the entries under path, descending into directories
+		// when recursive is set.
+		Tree(project, path, ref string, recursive bool) ([]*TreeEntry, error)
+		// Glob returns the content of every file matching any of the
+		// patterns, keyed by path.
+		Glob(project, ref string, patterns ...string) (map[string][]byte, error)
+	}
+	// Config selects and sets up a Source, it can be read from a file or
+	// from the environment with ConfigFromEnv.
+	Config struct {
+		// Backend is gitlab, local or git, gitlab when empty.
+		Backend string `yaml:"backend,omitempty" json:"backend,omitempty"`
+
+		// GitLab
+		BaseURL   string `yaml:"base_url,omitempty" json:"base_url,omitempty"`
+		Token     string `yaml:"token,omitempty" json:"token,omitempty"`
+		TokenType string `yaml:"token_type,omitempty" json:"token_type,omitempty"`
+		CacheDir  string `yaml:"cache_dir,omitempty" json:"cache_dir,omitempty"`
+		Offline   bool   `yaml:"offline,omitempty" json:"offline,omitempty"`
+
+		// Local and git: the directory of every project in Projects, Dir for
+		// all others.
+		Dir      string            `yaml:"dir,omitempty" json:"dir,omitempty"`
+		Projects map[string]string `yaml:"projects,omitempty" json:"projects,omitempty"`
+	}
+)
+
+// New creates the Source config selects. opts are added to the GitLab
+// backend's options.
+func New(config *Config, opts ...Option) (Source, error) {
+	switch config.Backend {
+	case "", BackendGitLab:
+		tokenType, err := ParseTokenType(config.TokenType)
+		if err != nil {
+			return nil, err
+		}
+		options := []Option{WithToken(config.Token, tokenType)}
+		if config.BaseURL != "" {
+			options = append(options, WithBaseURL(config.BaseURL))
+		}
+		if config.CacheDir != "" {
+			options = append(options, WithCache(config.CacheDir))
+		}
+		if config.Offline {
+			options = append(options, WithOffline())
+		}
+		return NewFetcher(append(options, opts...)...)
+	case BackendLocal:
+		local := NewLocal(config.Dir)
+		for project, dir := range config.Projects {
+			local.AddProject(project, dir)
+		}
+		return local, nil
+	case BackendGit:
+		git := NewGit(config.Dir)
+		for project, dir := range config.Projects {
+			git.AddProject(project, dir)
+		}
+		return git, nil
+	}
+	return nil, fmt.Errorf("unknown backend %q, use gitlab, local or git", config.Backend)
+}
+
+// ConfigFromEnv reads GETFILE_BACKEND, GETFILE_DIR, GETFILE_CACHE_DIR,
+// GITLAB_URL, GITLAB_TOKEN and GITLAB_TOKEN_TYPE. GETFILE_OFFLINE=true turns
+// on offline mode.
+func ConfigFromEnv() *Config {
+	return &Config{
+		Backend:   os.Getenv("GETFILE_BACKEND"),
+		BaseURL:   os.Getenv("GITLAB_URL"),
+		Token:     os.Getenv("GITLAB_TOKEN"),
+		TokenType: os.Getenv("GITLAB_TOKEN_TYPE"),
+		CacheDir:  os.Getenv("GETFILE_CACHE_DIR"),
+		Offline:   os.Getenv("GETFILE_OFFLINE") == "true",
+		Dir:       os.Getenv("GETFILE_DIR"),
+	}
+}
+
+// ParseTokenType parses private, job or oauth, private when empty.
+func ParseTokenType(s string) (TokenType, error) {
+	switch s {
+	case "", "private":
+		return PrivateToken, nil
+	case "job":
+		return JobToken, nil
+	case "oauth":
+		return OAuthToken, nil
+	}
+	return PrivateToken, fmt.Errorf("unknown token type %q, use private, job or oauth", s)
+}
@@ -0,0 +1,184 @@
+package getfile
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLocal(t *testing.T) {
+	dir, other := t.TempDir(), t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"README.md":                 "readme\n",
+		"services/api/service.yaml": "name: api\n",
+		"services/web/service.yaml": "name: web\n",
+		".git/HEAD":                 "ref: refs/heads/main\n",
+	})
+	writeFiles(t, other, map[string]string{"README.md": "other\n"})
+
+	local := NewLocal(dir)
+	local.AddProject("group/other", other)
+
+	t.Run("File", func(t *testing.T) {
+		tests := []struct {
+			project string
+			path    string
+			want    string
+			wantErr error
+		}{
+			{"group/project", "README.md", "readme\n", nil},
+			{"group/project", "./README.md", "readme\n", nil},
+			{"group/project", "/services/api/service.yaml", "name: api\n", nil},
+			{"group/other", "README.md", "other\n", nil},
+			{"group/project", "../README.md", "readme\n", nil},
+			{"group/project", "missing.md", "", fs.ErrNotExist},
+			{"group/other", "services/api/service.yaml", "", fs.ErrNotExist},
+		}
+		for _, test := range tests {
+			data, err := local.File(test.project, test.path, "main")
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("File(%q, %q) err = %v, want %v", test.project, test.path, err, test.wantErr)
+			}
+			if string(data) != test.want {
+				t.Errorf("File(%q, %q) = %q, want %q", test.project, test.path, data, test.want)
+			}
+		}
+	})
+
+	t.Run("Tree", func(t *testing.T) {
+		tests := []struct {
+			path      string
+			recursive bool
+			want      string
+		}{
+			{"", false, "README.md blob, services tree"},
+			{"", true, "README.md blob, services tree, services/api tree, services/api/service.yaml blob, services/web tree, services/web/service.yaml blob"},
+			{"services", false, "services/api tree, services/web tree"},
+			{"services/api", true, "services/api/service.yaml blob"},
+		}
+		for _, test := range tests {
+			entries, err := local.Tree("group/project", test.path, "", test.recursive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, entry := range entries {
+				got = append(got, entry.Path+" "+entry.Type)
+			}
+			if strings.Join(got, ", ") != test.want {
+				t.Errorf("Tree(%q, %v) = %s, want %s", test.path, test.recursive, strings.Join(got, ", "), test.want)
+			}
+		}
+
+		if _, err := local.Tree("group/project", "missing", "", true); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Tree(missing) err = %v, want %v", err, fs.ErrNotExist)
+		}
+	})
+
+	t.Run("Glob", func(t *testing.T) {
+		files, err := local.Glob("group/project", "", "services/*/service.yaml", "*.md")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 3 || string(files["services/web/service.yaml"]) != "name: web\n" || string(files["README.md"]) != "readme\n" {
+			t.Errorf("Glob = %q", files)
+		}
+	})
+
+	t.Run("Files", func(t *testing.T) {
+		if _, err := local.Files("group/project", "", "README.md", "missing.md"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Files err = %v, want %v", err, fs.ErrNotExist)
+		}
+	})
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("CI_JOB_TOKEN", "")
+	t.Setenv("CI_SERVER_URL", "")
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		check   func(src Source) bool
+		wantErr bool
+	}{
+		{
+			name:  "default",
+			env:   map[string]string{},
+			check: func(src Source) bool { _, ok := src.(*Fetcher); return ok },
+		},
+		{
+			name: "gitlab",
+			env: map[string]string{
+				"GETFILE_BACKEND":   "gitlab",
+				"GITLAB_URL":        "https://gitlab.example.com",
+				"GITLAB_TOKEN":      "token",
+				"GITLAB_TOKEN_TYPE": "oauth",
+				"GETFILE_CACHE_DIR": dir,
+				"GETFILE_OFFLINE":   "true",
+			},
+			check: func(src Source) bool {
+				fetcher, ok := src.(*Fetcher)
+				return ok && fetcher.offline && fetcher.cache != nil
+			},
+		},
+		{
+			name:  "local",
+			env:   map[string]string{"GETFILE_BACKEND": "local", "GETFILE_DIR": dir},
+			check: func(src Source) bool { local, ok := src.(*Local); return ok && local.root("any") == dir },
+		},
+		{
+			name:  "git",
+			env:   map[string]string{"GETFILE_BACKEND": "git", "GETFILE_DIR": dir},
+			check: func(src Source) bool { git, ok := src.(*Git); return ok && git.root("any") == dir },
+		},
+		{
+			name:    "unknown backend",
+			env:     map[string]string{"GETFILE_BACKEND": "svn"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown token type",
+			env:     map[string]string{"GITLAB_TOKEN_TYPE": "deploy"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, name := range []string{"GETFILE_BACKEND", "GETFILE_DIR", "GETFILE_CACHE_DIR", "GETFILE_OFFLINE", "GITLAB_URL", "GITLAB_TOKEN", "GITLAB_TOKEN_TYPE"} {
+				t.Setenv(name, test.env[name])
+			}
+
+			config := ConfigFromEnv()
+			if config.Backend != test.env["GETFILE_BACKEND"] || config.Dir != test.env["GETFILE_DIR"] || config.Token != test.env["GITLAB_TOKEN"] {
+				t.Errorf("ConfigFromEnv() = %+v", config)
+			}
+
+			src, err := New(config)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("New() err = %v, want error %v", err, test.wantErr)
+			}
+			if err == nil && !test.check(src) {
+				t.Errorf("New() = %#v", src)
+			}
+		})
+	}
+}
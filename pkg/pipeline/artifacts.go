@@ -0,0 +1,194 @@
+package pipeline
+
+import "fmt"
+
+type (
+	Artifacts struct {
+		Name      string            `yaml:"name,omitempty"`
+		Paths     []string          `yaml:",omitempty"`
+		Exclude   []string          `yaml:"exclude,omitempty"`
+		ExpireIn  string            `yaml:"expire_in,omitempty"`
+		ExposeAs  string            `yaml:"expose_as,omitempty"`
+		Untracked bool              `yaml:"untracked,omitempty"`
+		When      string            `yaml:"when,omitempty"`
+		Reports   *ArtifactsReports `yaml:"reports,omitempty"`
+	}
+	// https://docs.gitlab.com/ee/ci/yaml/artifacts_reports.html
+	ArtifactsReports struct {
+		Accessibility      []string        `yaml:"accessibility,omitempty"`
+		Annotations        []string        `yaml:"annotations,omitempty"`
+		APIFuzzing         []string        `yaml:"api_fuzzing,omitempty"`
+		BrowserPerformance []string        `yaml:"browser_performance,omitempty"`
+		CoverageReport     *CoverageReport `yaml:"coverage_report,omitempty"`
+		Codequality        []string        `yaml:"codequality,omitempty"`
+		ContainerScanning  []string        `yaml:"container_scanning,omitempty"`
+		CoverageFuzzing    []string        `yaml:"coverage_fuzzing,omitempty"`
+		CycloneDX          []string        `yaml:"cyclonedx,omitempty"`
+		DAST               []string        `yaml:"dast,omitempty"`
+		DependencyScanning []string        `yaml:"dependency_scanning,omitempty"`
+		Dotenv             []string        `yaml:"dotenv,omitempty"`
+		JUnit              []string        `yaml:"junit,omitempty"`
+		LoadPerformance    []string        `yaml:"load_performance,omitempty"`
+		Metrics            []string        `yaml:"metrics,omitempty"`
+		Requirements       []string        `yaml:"requirements,omitempty"`
+		SAST               []string        `yaml:"sast,omitempty"`
+		SecretDetection    []string        `yaml:"secret_detection,omitempty"`
+		Terraform          []string        `yaml:"terraform,omitempty"`
+	}
+	// CoverageFormat is cobertura or jacoco.
+	CoverageReport struct {
+		CoverageFormat string `yaml:"coverage_format"`
+		Path           string `yaml:"path"`
+	}
+)
+
+func (this *Job) artifacts() *Artifacts {
+	if this.Artifacts == nil {
+		this.Artifacts = &Artifacts{
+			Paths: []string{},
+		}
+	}
+	return this.Artifacts
+}
+
+func (this *Job) reports() *ArtifactsReports {
+	artifacts := this.artifacts()
+	if artifacts.Reports == nil {
+		artifacts.Reports = &ArtifactsReports{}
+	}
+	return artifacts.Reports
+}
+
+func (this *Job) AddArtifact(format string, a ...any) {
+	file := fmt.Sprintf(format, a...)
+	artifacts := this.artifacts()
+	artifacts.Paths = append(artifacts.Paths, file)
+}
+
+func (this *Job) ExcludeArtifact(format string, a ...any) {
+	file := fmt.Sprintf(format, a...)
+	artifacts := this.artifacts()
+	artifacts.Exclude = append(artifacts.Exclude, file)
+}
+
+func (this *Job) SetArtifactsName(format string, a ...any) {
+	this.artifacts().Name = fmt.Sprintf(format, a...)
+}
+
+// BuildJob.SetArtifactsExpireIn("1 week")
+func (this *Job) SetArtifactsExpireIn(duration string) {
+	this.artifacts().ExpireIn = duration
+}
+
+// Link the artifacts in merge requests under name.
+func (this *Job) SetArtifactsExposeAs(name string) {
+	this.artifacts().ExposeAs = name
+}
+
+// on_success, on_failure or always
+func (this *Job) SetArtifactsWhen(when string) {
+	this.artifacts().When = when
+}
+
+func (this *Job) SetArtifactsUntracked(untracked bool) {
+	this.artifacts().Untracked = untracked
+}
+
+// Test reports are usually wanted on failure too, see SetArtifactsWhen.
+func (this *Job) AddJUnitReport(paths ...string) {
+	reports := this.reports()
+	reports.JUnit = append(reports.JUnit, paths...)
+}
+
+// TestJob.SetCoverageReport("cobertura", "coverage.xml")
+func (this *Job) SetCoverageReport(format, path string) {
+	this.reports().CoverageReport = &CoverageReport{
+		CoverageFormat: format,
+		Path:           path,
+	}
+}
+
+func (this *Job) AddDotenvReport(paths ...string) {
+	reports := this.reports()
+	reports.Dotenv = append(reports.Dotenv, paths...)
+}
+
+func (this *Job) AddAccessibilityReport(paths ...string) {
+	reports := this.reports()
+	reports.Accessibility = append(reports.Accessibility, paths...)
+}
+
+func (this *Job) AddAnnotationsReport(paths ...string) {
+	reports := this.reports()
+	reports.Annotations = append(reports.Annotations, paths...)
+}
+
+func (this *Job) AddAPIFuzzingReport(paths ...string) {
+	reports := this.reports()
+	reports.APIFuzzing = append(reports.APIFuzzing, paths...)
+}
+
+func (this *Job) AddBrowserPerformanceReport(paths ...string) {
+	reports := this.reports()
+	reports.BrowserPerformance = append(reports.BrowserPerformance, paths...)
+}
+
+func (this *Job) AddCodequalityReport(paths ...string) {
+	reports := this.reports()
+	reports.Codequality = append(reports.Codequality, paths...)
+}
+
+func (this *Job) AddContainerScanningReport(paths ...string) {
+	reports := this.reports()
+	reports.ContainerScanning = append(reports.ContainerScanning, paths...)
+}
+
+func (this *Job) AddCoverageFuzzingReport(paths ...string) {
+	reports := this.reports()
+	reports.CoverageFuzzing = append(reports.CoverageFuzzing, paths...)
+}
+
+func (this *Job) AddCycloneDXReport(paths ...string) {
+	reports := this.reports()
+	reports.CycloneDX = append(reports.CycloneDX, paths...)
+}
+
+func (this *Job) AddDASTReport(paths ...string) {
+	reports := this.reports()
+	reports.DAST = append(reports.DAST, paths...)
+}
+
+func (this *Job) AddDependencyScanningReport(paths ...string) {
+	reports := this.reports()
+	reports.DependencyScanning = append(reports.DependencyScanning, paths...)
+}
+
+func (this *Job) AddLoadPerformanceReport(paths ...string) {
+	reports := this.reports()
+	reports.LoadPerformance = append(reports.LoadPerformance, paths...)
+}
+
+func (this *Job) AddMetricsReport(paths ...string) {
+	reports := this.reports()
+	reports.Metrics = append(reports.Metrics, paths...)
+}
+
+func (this *Job) AddRequirementsReport(paths ...string) {
+	reports := this.reports()
+	reports.Requirements = append(reports.Requirements, paths...)
+}
+
+func (this *Job) AddSASTReport(paths ...string) {
+	reports := this.reports()
+	reports.SAST = append(reports.SAST, paths...)
+}
+
+func (this *Job) AddSecretDetectionReport(paths ...string) {
+	reports := this.reports()
+	reports.SecretDetection = append(reports.SecretDetection, paths...)
+}
+
+func (this *Job) AddTerraformReport(paths ...string) {
+	reports := this.reports()
+	reports.Terraform = append(reports.Terraform, paths...)
+}
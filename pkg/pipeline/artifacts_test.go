@@ -0,0 +1,110 @@
+package pipeline
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestArtifactsRender(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(job *Job)
+		want  string
+	}{
+		{
+			name:  "none",
+			build: func(job *Job) {},
+			want:  "job: {}\n",
+		},
+		{
+			name: "paths",
+			build: func(job *Job) {
+				job.AddArtifact("bin/%s", "app")
+				job.ExcludeArtifact("bin/*.tmp")
+				job.SetArtifactsName("build-%d", 1)
+				job.SetArtifactsExpireIn("1 week")
+				job.SetArtifactsExposeAs("binaries")
+				job.SetArtifactsWhen("always")
+				job.SetArtifactsUntracked(true)
+			},
+			want: `job:
+    artifacts:
+        name: build-1
+        paths:
+            - bin/app
+        exclude:
+            - bin/*.tmp
+        expire_in: 1 week
+        expose_as: binaries
+        untracked: true
+        when: always
+`,
+		},
+		{
+			name: "reports only",
+			build: func(job *Job) {
+				job.AddJUnitReport("report.xml", "other.xml")
+				job.SetCoverageReport("cobertura", "coverage.xml")
+				job.AddDotenvReport("build.env")
+			},
+			want: `job:
+    artifacts:
+        reports:
+            coverage_report:
+                coverage_format: cobertura
+                path: coverage.xml
+            dotenv:
+                - build.env
+            junit:
+                - report.xml
+                - other.xml
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			job := &Job{}
+			test.build(job)
+			out, err := MarshalE("job", job)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != test.want {
+				t.Errorf("got\n%s\nwant\n%s", out, test.want)
+			}
+		})
+	}
+}
+
+func TestArtifactsParse(t *testing.T) {
+	p, err := Parse([]byte(`
+job:
+  script: [a]
+  artifacts:
+    paths: bin/
+    exclude: bin/*.tmp
+    reports:
+      junit: report.xml
+      sast: [gl-sast.json]
+      coverage_report:
+        coverage_format: jacoco
+        path: jacoco.xml
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Artifacts{
+		Paths:   []string{"bin/"},
+		Exclude: []string{"bin/*.tmp"},
+		Reports: &ArtifactsReports{
+			JUnit:          []string{"report.xml"},
+			SAST:           []string{"gl-sast.json"},
+			CoverageReport: &CoverageReport{CoverageFormat: "jacoco", Path: "jacoco.xml"},
+		},
+	}
+	if got := p.lookup("job").Artifacts; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,162 @@
+package pipeline
+
+import "gopkg.in/yaml.v3"
+
+const (
+	CachePolicyPull     = "pull"
+	CachePolicyPush     = "push"
+	CachePolicyPullPush = "pull-push"
+)
+
+type (
+	// https://docs.gitlab.com/ee/ci/yaml/#cache
+	// KeyFiles, with an optional KeyPrefix, replaces Key with a key computed
+	// from the latest commit that changed those files.
+	JobCache struct {
+		Key          string
+		KeyFiles     []string
+		KeyPrefix    string
+		Paths        []string
+		Policy       string
+		FallbackKeys []string
+		Untracked    bool
+		Unprotect    bool
+		When         string
+	}
+	jobCacheKey struct {
+		Files  []string `yaml:"files,omitempty"`
+		Prefix string   `yaml:"prefix,omitempty"`
+	}
+	jobCacheYAML struct {
+		Key          any      `yaml:"key,omitempty"`
+		Paths        []string `yaml:"paths,omitempty"`
+		Policy       string   `yaml:"policy,omitempty"`
+		FallbackKeys []string `yaml:"fallback_keys,omitempty"`
+		Untracked    bool     `yaml:"untracked,omitempty"`
+		Unprotect    bool     `yaml:"unprotect,omitempty"`
+		When         string   `yaml:"when,omitempty"`
+	}
+)
+
+// GoJob.CacheKey("$CI_COMMIT_REF_SLUG", ".go/pkg/mod").Pull()
+func (this *Job) CacheKey(key string, paths ...string) *JobCache {
+	cache := &JobCache{Key: key, Paths: paths}
+	this.Cache = append(this.Cache, cache)
+	return cache
+}
+
+// NodeJob.CacheFiles([]string{"package-lock.json"}, ".npm/").SetPrefix("$CI_JOB_NAME")
+func (this *Job) CacheFiles(files []string, paths ...string) *JobCache {
+	cache := &JobCache{KeyFiles: files, Paths: paths}
+	this.Cache = append(this.Cache, cache)
+	return cache
+}
+
+func (this *Pipeline) CacheKey(key string, paths ...string) *JobCache {
+	cache := &JobCache{Key: key, Paths: paths}
+	this.Cache = append(this.Cache, cache)
+	return cache
+}
+
+func (this *Pipeline) CacheFiles(files []string, paths ...string) *JobCache {
+	cache := &JobCache{KeyFiles: files, Paths: paths}
+	this.Cache = append(this.Cache, cache)
+	return cache
+}
+
+func (this *JobCache) SetPrefix(prefix string) *JobCache {
+	this.KeyPrefix = prefix
+	return this
+}
+
+// pull, push or pull-push
+func (this *JobCache) SetPolicy(policy string) *JobCache {
+	this.Policy = policy
+	return this
+}
+
+// Pull only downloads the cache, for jobs that consume but never change it.
+func (this *JobCache) Pull() *JobCache {
+	return this.SetPolicy(CachePolicyPull)
+}
+
+// Push only uploads the cache, for jobs that build it from scratch.
+func (this *JobCache) Push() *JobCache {
+	return this.SetPolicy(CachePolicyPush)
+}
+
+// Keys to try, in order, when there is no cache for the main key yet.
+func (this *JobCache) AddFallbackKeys(keys ...string) *JobCache {
+	this.FallbackKeys = append(this.FallbackKeys, keys...)
+	return this
+}
+
+func (this *JobCache) SetUntracked(untracked bool) *JobCache {
+	this.Untracked = untracked
+	return this
+}
+
+// Share the cache between protected and unprotected branches.
+func (this *JobCache) SetUnprotect(unprotect bool) *JobCache {
+	this.Unprotect = unprotect
+	return this
+}
+
+// on_success, on_failure or always
+func (this *JobCache) SetWhen(when string) *JobCache {
+	this.When = when
+	return this
+}
+
+func (this *JobCache) MarshalYAML() (any, error) {
+	out := &jobCacheYAML{
+		Paths:        this.Paths,
+		Policy:       this.Policy,
+		FallbackKeys: this.FallbackKeys,
+		Untracked:    this.Untracked,
+		Unprotect:    this.Unprotect,
+		When:         this.When,
+	}
+	if len(this.KeyFiles) > 0 {
+		out.Key = &jobCacheKey{Files: this.KeyFiles, Prefix: this.KeyPrefix}
+	} else if this.Key != "" {
+		out.Key = this.Key
+	}
+	return out, nil
+}
+
+func (this *JobCache) UnmarshalYAML(node *yaml.Node) error {
+	in := &jobCacheYAML{}
+	if err := node.Decode(in); err != nil {
+		return err
+	}
+
+	*this = JobCache{
+		Paths:        in.Paths,
+		Policy:       in.Policy,
+		FallbackKeys: in.FallbackKeys,
+		Untracked:    in.Untracked,
+		Unprotect:    in.Unprotect,
+		When:         in.When,
+	}
+
+	key := getKey(node, "key")
+	if key != nil && key.Kind == yaml.AliasNode {
+		key = key.Alias
+	}
+	if key == nil {
+		return nil
+	}
+
+	if key.Kind != yaml.MappingNode {
+		return key.Decode(&this.Key)
+	}
+	setKey(key, "files", toList(getKey(key, "files")))
+	files := &jobCacheKey{}
+	if err := key.Decode(files); err != nil {
+		return err
+	}
+	this.KeyFiles, this.KeyPrefix = files.Files, files.Prefix
+
+	return nil
+}
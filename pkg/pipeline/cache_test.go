@@ -0,0 +1,133 @@
+package pipeline
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestJobCacheRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		cache *JobCache
+		want  string
+	}{
+		{
+			name:  "key",
+			cache: &JobCache{Key: "go", Paths: []string{".cache/"}},
+			want:  "key: go\npaths:\n    - .cache/\n",
+		},
+		{
+			name:  "key files",
+			cache: &JobCache{KeyFiles: []string{"go.sum"}, KeyPrefix: "$CI_JOB_NAME", Paths: []string{".cache/"}},
+			want:  "key:\n    files:\n        - go.sum\n    prefix: $CI_JOB_NAME\npaths:\n    - .cache/\n",
+		},
+		{
+			name:  "key files win over key",
+			cache: &JobCache{Key: "ignored", KeyFiles: []string{"go.sum"}},
+			want:  "key:\n    files:\n        - go.sum\n",
+		},
+		{
+			name: "everything",
+			cache: &JobCache{
+				Key:          "npm",
+				Paths:        []string{".npm/"},
+				Policy:       CachePolicyPull,
+				FallbackKeys: []string{"npm-main", "npm"},
+				Untracked:    true,
+				Unprotect:    true,
+				When:         "always",
+			},
+			want: `key: npm
+paths:
+    - .npm/
+policy: pull
+fallback_keys:
+    - npm-main
+    - npm
+untracked: true
+unprotect: true
+when: always
+`,
+		},
+		{
+			name:  "empty",
+			cache: &JobCache{},
+			want:  "{}\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := yaml.Marshal(test.cache)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != test.want {
+				t.Fatalf("got\n%s\nwant\n%s", out, test.want)
+			}
+
+			parsed := &JobCache{}
+			if err := yaml.Unmarshal(out, parsed); err != nil {
+				t.Fatal(err)
+			}
+			again, err := yaml.Marshal(parsed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(again) != string(out) {
+				t.Errorf("round trip changed the cache\nfirst:\n%s\nsecond:\n%s", out, again)
+			}
+		})
+	}
+}
+
+func TestJobCacheParse(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want *JobCache
+	}{
+		{
+			name: "single path and key file",
+			yaml: "key:\n  files: go.sum\npaths: .cache/\n",
+			want: &JobCache{KeyFiles: []string{"go.sum"}, Paths: []string{".cache/"}},
+		},
+		{
+			name: "anchored key",
+			yaml: "key: &key go\npaths: [.cache/]\n",
+			want: &JobCache{Key: "go", Paths: []string{".cache/"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := &yaml.Node{}
+			if err := yaml.Unmarshal([]byte(test.yaml), node); err != nil {
+				t.Fatal(err)
+			}
+			got := []*JobCache{}
+			if err := normalizeCache(node.Content[0]).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 || !reflect.DeepEqual(got[0], test.want) {
+				t.Errorf("got %+v, want %+v", got[0], test.want)
+			}
+		})
+	}
+}
+
+func TestCacheBuilders(t *testing.T) {
+	job := NewJob("job")
+	job.CacheKey("$CI_COMMIT_REF_SLUG", ".go/").Pull().AddFallbackKeys("main")
+	job.CacheFiles([]string{"package-lock.json"}, ".npm/").SetPrefix("$CI_JOB_NAME").Push().SetWhen("on_success")
+
+	want := []*JobCache{
+		{Key: "$CI_COMMIT_REF_SLUG", Paths: []string{".go/"}, Policy: CachePolicyPull, FallbackKeys: []string{"main"}},
+		{KeyFiles: []string{"package-lock.json"}, KeyPrefix: "$CI_JOB_NAME", Paths: []string{".npm/"}, Policy: CachePolicyPush, When: "on_success"},
+	}
+	if !reflect.DeepEqual(job.Cache, want) {
+		t.Errorf("got %+v, want %+v", job.Cache, want)
+	}
+}
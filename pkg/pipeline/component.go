@@ -0,0 +1,295 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	InputString  = "string"
+	InputNumber  = "number"
+	InputBoolean = "boolean"
+	InputArray   = "array"
+)
+
+var (
+	ErrUndeclaredInput = errors.New("undeclared input")
+	ErrInvalidInput    = errors.New("invalid input")
+
+	// $[[ inputs.name ]], optionally followed by | functions.
+	inputUse = regexp.MustCompile(`\$\[\[\s*inputs\.([A-Za-z0-9_-]+)\s*(\|[^\]]*)?\]\]`)
+)
+
+type (
+	// Component is a CI/CD component: a pipeline whose jobs use
+	// $[[ inputs.name ]], rendered after a spec document declaring the
+	// inputs.
+	Component struct {
+		*Pipeline
+		Inputs []*Input
+	}
+	// Input is an input of a component. An input without a default must be
+	// given when the component is included.
+	Input struct {
+		Name        string
+		Description string
+		Type        string
+		Default     any
+		Options     []any
+		Regex       string
+		hasDefault  bool
+	}
+)
+
+func NewComponent(name string) *Component {
+	return &Component{
+		Pipeline: NewPipeline(name),
+		Inputs:   []*Input{},
+	}
+}
+
+// AddInput declares an input of type InputString, InputNumber, InputBoolean
+// or InputArray.
+func (this *Component) AddInput(name, inputType string) *Input {
+	input := &Input{Name: name, Type: inputType}
+	this.Inputs = append(this.Inputs, input)
+	return input
+}
+
+func (this *Input) SetDescription(description string) *Input {
+	this.Description = description
+	return this
+}
+
+// SetDefault makes the input optional, v is used when it is not given.
+func (this *Input) SetDefault(v any) *Input {
+	this.Default = v
+	this.hasDefault = true
+	return this
+}
+
+// SetOptions limits the input to the values given.
+func (this *Input) SetOptions(options ...any) *Input {
+	this.Options = options
+	return this
+}
+
+// SetRegex makes string inputs match regex, an RE2 expression like GitLab
+// uses.
+func (this *Input) SetRegex(regex string) *Input {
+	this.Regex = regex
+	return this
+}
+
+// Inputs render in the order they were added, an input with nothing but its
+// name as name: with no value.
+func (this *Component) spec() (string, error) {
+	inputs := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, input := range this.Inputs {
+		value, err := encodeNode(input)
+		if err != nil {
+			return "", fmt.Errorf("input %s: %w", input.Name, err)
+		}
+		inputs.Content = append(inputs.Content, scalar(input.Name), value)
+	}
+
+	spec := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	spec.Content = append(spec.Content, scalar("spec"), mapping("inputs", inputs))
+	out, err := yaml.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// Render calls log.Fatal on any error, use RenderE to handle them instead.
+func (this *Component) Render() string {
+	out, err := this.RenderE()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return out
+}
+
+// RenderE renders the component file: the spec document, ---, and the
+// pipeline.
+func (this *Component) RenderE() (string, error) {
+	spec, err := this.spec()
+	if err != nil {
+		return "", err
+	}
+	out, err := this.Pipeline.RenderE()
+	return spec + "---\n" + out, err
+}
+
+// Validate checks the pipeline and the inputs: types, defaults and options
+// of the declared type, defaults among the options and matching the regex,
+// and that every $[[ inputs.name ]] the pipeline uses is declared.
+func (this *Component) Validate() error {
+	errs := Errors{}
+	if err := this.Pipeline.Validate(); err != nil {
+		errs = append(errs, err.(Errors)...)
+	}
+
+	declared := map[string]bool{}
+	for _, input := range this.Inputs {
+		if declared[input.Name] {
+			errs.add(this.Pipeline, nil, nil, fmt.Errorf("%w %s: declared twice", ErrInvalidInput, input.Name))
+		}
+		declared[input.Name] = true
+		for _, err := range input.validate() {
+			errs.add(this.Pipeline, nil, nil, fmt.Errorf("%w %s: %v", ErrInvalidInput, input.Name, err))
+		}
+	}
+
+	used := map[string]bool{}
+	check := func(stage *Stage, job *Job, key string, o any) {
+		out, err := MarshalE(key, o)
+		if err != nil {
+			errs.add(this.Pipeline, stage, job, err)
+			return
+		}
+		for _, match := range inputUse.FindAllStringSubmatch(out, -1) {
+			used[match[1]] = true
+			if !declared[match[1]] {
+				errs.add(this.Pipeline, stage, job, fmt.Errorf("%w: %s", ErrUndeclaredInput, match[1]))
+			}
+		}
+	}
+
+	check(nil, nil, "default", this.Default)
+	check(nil, nil, "workflow", this.Workflow)
+	check(nil, nil, "cache", this.Cache)
+	check(nil, nil, "include", this.Includes)
+	check(nil, nil, "variables", this.Variables)
+	for _, template := range this.Templates {
+		check(nil, template, template.Name, template)
+	}
+	for _, stage := range this.Stages {
+		check(stage, nil, "stage", stage.Name)
+		for _, job := range stage.Jobs {
+			check(stage, job, job.Name, job)
+		}
+	}
+
+	for _, input := range this.Inputs {
+		if !used[input.Name] {
+			log.Warnf("component %s: input %s is never used", this.Name, input.Name)
+		}
+	}
+
+	return errs.err()
+}
+
+func (this *Input) validate() []error {
+	errs := []error{}
+
+	switch this.Type {
+	case "", InputString, InputNumber, InputBoolean, InputArray:
+	default:
+		return append(errs, fmt.Errorf("unknown type %q", this.Type))
+	}
+
+	var re *regexp.Regexp
+	if this.Regex != "" {
+		var err error
+		if this.Type != "" && this.Type != InputString {
+			errs = append(errs, fmt.Errorf("regex is only for string inputs"))
+		} else if re, err = regexp.Compile(this.Regex); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, option := range this.Options {
+		if !this.is(option) {
+			errs = append(errs, fmt.Errorf("option %v is not a %s", option, this.typeName()))
+		}
+	}
+
+	if this.hasDefault {
+		switch {
+		case !this.is(this.Default):
+			errs = append(errs, fmt.Errorf("default %v is not a %s", this.Default, this.typeName()))
+		case len(this.Options) > 0 && !containsValue(this.Options, this.Default):
+			errs = append(errs, fmt.Errorf("default %v is not one of the options", this.Default))
+		case re != nil && !re.MatchString(fmt.Sprint(this.Default)):
+			errs = append(errs, fmt.Errorf("default %v does not match %s", this.Default, this.Regex))
+		}
+	}
+
+	return errs
+}
+
+// is reports whether v is a value of the input's type.
+func (this *Input) is(v any) bool {
+	value := reflect.ValueOf(v)
+	if !value.IsValid() {
+		return false
+	}
+	switch this.typeName() {
+	case InputNumber:
+		return value.CanInt() || value.CanUint() || value.CanFloat()
+	case InputBoolean:
+		return value.Kind() == reflect.Bool
+	case InputArray:
+		return value.Kind() == reflect.Slice || value.Kind() == reflect.Array
+	}
+	return value.Kind() == reflect.String
+}
+
+func (this *Input) typeName() string {
+	if this.Type == "" {
+		return InputString
+	}
+	return this.Type
+}
+
+func (this *Input) MarshalYAML() (any, error) {
+	fields := []struct {
+		key   string
+		value any
+		set   bool
+	}{
+		{"description", this.Description, this.Description != ""},
+		{"type", this.Type, this.Type != ""},
+		{"default", this.Default, this.hasDefault},
+		{"options", this.Options, len(this.Options) > 0},
+		{"regex", this.Regex, this.Regex != ""},
+	}
+
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, field := range fields {
+		if !field.set {
+			continue
+		}
+		value, err := encodeNode(field.value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field.key, err)
+		}
+		node.Content = append(node.Content, scalar(field.key), value)
+	}
+
+	if len(node.Content) == 0 {
+		return nil, nil
+	}
+	return node, nil
+}
+
+// containsValue compares by value, so an int default matches an int64 option.
+func containsValue(values []any, v any) bool {
+	for _, value := range values {
+		if reflect.DeepEqual(value, v) || fmt.Sprint(value) == fmt.Sprint(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns how the input is used in a component, $[[ inputs.name ]].
+func (this *Input) String() string {
+	return "$[[ inputs." + this.Name + " ]]"
+}
@@ -0,0 +1,174 @@
+package pipeline
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestComponentRender(t *testing.T) {
+	c := NewComponent("go")
+	stage := c.AddInput("stage", InputString).SetDefault("test").SetDescription("Stage of the job")
+	c.AddInput("count", InputNumber).SetDefault(2).SetOptions(1, 2, 3)
+	c.AddInput("race", InputBoolean).SetDefault(false)
+	c.AddInput("flags", InputArray).SetDefault([]string{})
+	version := c.AddInput("version", InputString).SetRegex(`^1\.\d+$`)
+	job := c.Stage(stage.String()).Job("test")
+	job.SetImage("golang:%s", version)
+	job.AddCommand("go test")
+
+	out, err := c.RenderE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, pipeline, ok := strings.Cut(out, "---\n")
+	if !ok {
+		t.Fatalf("no document separator in\n%s", out)
+	}
+
+	want := `spec:
+    inputs:
+        stage:
+            description: Stage of the job
+            type: string
+            default: test
+        count:
+            type: number
+            default: 2
+            options:
+                - 1
+                - 2
+                - 3
+        race:
+            type: boolean
+            default: false
+        flags:
+            type: array
+            default: []
+        version:
+            type: string
+            regex: ^1\.\d+$
+`
+	if spec != want {
+		t.Errorf("spec\n%s\nwant\n%s", spec, want)
+	}
+	if !strings.Contains(pipeline, "image:\n        name: golang:$[[ inputs.version ]]\n") {
+		t.Errorf("input not used in\n%s", pipeline)
+	}
+	if _, err := Parse([]byte(pipeline)); err != nil {
+		t.Errorf("pipeline does not parse: %v", err)
+	}
+}
+
+func TestComponentRenderError(t *testing.T) {
+	c := NewComponent("broken")
+	c.AddInput("f", InputString).SetDefault(func() {})
+	if _, err := c.RenderE(); err == nil {
+		t.Fatal("rendered a func default")
+	}
+}
+
+func TestComponentValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		build   func(c *Component)
+		wantErr []error
+	}{
+		{
+			name: "valid",
+			build: func(c *Component) {
+				c.AddInput("name", InputString).SetDefault("a").SetOptions("a", "b").SetRegex("^[ab]$")
+				c.AddInput("count", InputNumber).SetDefault(int64(1)).SetOptions(1, 2)
+				c.Stage("test").Job("$[[ inputs.name ]]").AddCommand("echo $[[ inputs.count | expand_vars ]]")
+			},
+		},
+		{
+			name: "undeclared",
+			build: func(c *Component) {
+				c.Stage("test").Job("test").AddCommand("echo $[[ inputs.missing ]]")
+			},
+			wantErr: []error{ErrUndeclaredInput},
+		},
+		{
+			name: "undeclared in variables",
+			build: func(c *Component) {
+				c.AddVariable("A", "$[[inputs.missing]]", "")
+				c.Stage("test").Job("test").AddCommand("true")
+			},
+			wantErr: []error{ErrUndeclaredInput},
+		},
+		{
+			name: "declared twice",
+			build: func(c *Component) {
+				c.AddInput("a", InputString)
+				c.AddInput("a", InputString)
+			},
+			wantErr: []error{ErrInvalidInput},
+		},
+		{
+			name: "unknown type",
+			build: func(c *Component) {
+				c.AddInput("a", "object")
+			},
+			wantErr: []error{ErrInvalidInput},
+		},
+		{
+			name: "default of the wrong type",
+			build: func(c *Component) {
+				c.AddInput("a", InputNumber).SetDefault("1")
+				c.AddInput("b", InputBoolean).SetDefault("true")
+				c.AddInput("c", InputArray).SetDefault("a")
+			},
+			wantErr: []error{ErrInvalidInput, ErrInvalidInput, ErrInvalidInput},
+		},
+		{
+			name: "default not an option",
+			build: func(c *Component) {
+				c.AddInput("a", InputString).SetDefault("c").SetOptions("a", "b")
+			},
+			wantErr: []error{ErrInvalidInput},
+		},
+		{
+			name: "default does not match",
+			build: func(c *Component) {
+				c.AddInput("a", InputString).SetDefault("c").SetRegex("^[ab]$")
+			},
+			wantErr: []error{ErrInvalidInput},
+		},
+		{
+			name: "regex on a number",
+			build: func(c *Component) {
+				c.AddInput("a", InputNumber).SetRegex("^1$")
+			},
+			wantErr: []error{ErrInvalidInput},
+		},
+		{
+			name: "invalid regex",
+			build: func(c *Component) {
+				c.AddInput("a", InputString).SetRegex("(")
+			},
+			wantErr: []error{ErrInvalidInput},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := NewComponent("test")
+			test.build(c)
+
+			err := c.Validate()
+			errs := Errors{}
+			if err != nil && !errors.As(err, &errs) {
+				t.Fatalf("err = %v, want Errors", err)
+			}
+			if len(errs) != len(test.wantErr) {
+				t.Fatalf("got %d errors, want %d: %v", len(errs), len(test.wantErr), err)
+			}
+			for i, want := range test.wantErr {
+				if !errors.Is(errs[i], want) {
+					t.Errorf("error %d = %v, want %v", i, errs[i], want)
+				}
+			}
+		})
+	}
+}
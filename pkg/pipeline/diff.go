@@ -0,0 +1,363 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ChangeAdded   ChangeType = "added"
+	ChangeRemoved ChangeType = "removed"
+	ChangeRenamed ChangeType = "renamed"
+	ChangeMoved   ChangeType = "moved"
+	ChangeChanged ChangeType = "changed"
+)
+
+type (
+	ChangeType string
+	// PipelineDiff is what changed between two pipelines, as GitLab would see
+	// it rather than as text.
+	PipelineDiff struct {
+		// Changes to default, workflow, include, variables and cache.
+		Pipeline []*FieldChange `json:"pipeline,omitempty"`
+		Stages   []*StageChange `json:"stages,omitempty"`
+		Jobs     []*JobChange   `json:"jobs,omitempty"`
+	}
+	StageChange struct {
+		Type    ChangeType `json:"type"`
+		Name    string     `json:"name"`
+		OldName string     `json:"old_name,omitempty"`
+		// Positions among the stages both pipelines have, for moved stages.
+		OldIndex *int `json:"old_index,omitempty"`
+		Index    *int `json:"index,omitempty"`
+	}
+	JobChange struct {
+		Type    ChangeType     `json:"type"`
+		Name    string         `json:"name"`
+		OldName string         `json:"old_name,omitempty"`
+		Stage   string         `json:"stage"`
+		Fields  []*FieldChange `json:"fields,omitempty"`
+	}
+	// Field is the YAML key, map entries are field.key, such as
+	// variables.GOFLAGS. Before and After are the rendered YAML, empty when
+	// the key is not set.
+	FieldChange struct {
+		Field  string `json:"field"`
+		Before string `json:"before,omitempty"`
+		After  string `json:"after,omitempty"`
+	}
+)
+
+// Diff compares two pipelines. Stages and jobs are matched by name, a removed
+// stage and an added stage holding the same jobs count as a rename, and so do
+// a removed job and an added job that are otherwise identical. A nil pipeline
+// is empty, so everything in the other one is added or removed.
+func Diff(a, b *Pipeline) *PipelineDiff {
+	if a == nil {
+		a = &Pipeline{}
+	}
+	if b == nil {
+		b = &Pipeline{}
+	}
+	diff := &PipelineDiff{
+		Pipeline: diffFields(a, b, "Name", "Stages"),
+		Stages:   []*StageChange{},
+		Jobs:     []*JobChange{},
+	}
+	diff.diffStages(a, b)
+	diff.diffJobs(a, b)
+
+	return diff
+}
+
+func (this *PipelineDiff) diffStages(a, b *Pipeline) {
+	before, after := stageJobNames(a), stageJobNames(b)
+	removed, added := []string{}, []string{}
+	common := map[string]bool{}
+
+	for _, stage := range a.Stages {
+		if _, ok := after[stage.Name]; ok {
+			common[stage.Name] = true
+		} else {
+			removed = append(removed, stage.Name)
+		}
+	}
+	for _, stage := range b.Stages {
+		if _, ok := before[stage.Name]; !ok {
+			added = append(added, stage.Name)
+		}
+	}
+
+	for _, old := range removed {
+		renamed := false
+		for i, name := range added {
+			if len(before[old]) > 0 && reflect.DeepEqual(before[old], after[name]) {
+				this.Stages = append(this.Stages, &StageChange{Type: ChangeRenamed, Name: name, OldName: old})
+				added = append(added[:i], added[i+1:]...)
+				renamed = true
+				break
+			}
+		}
+		if !renamed {
+			this.Stages = append(this.Stages, &StageChange{Type: ChangeRemoved, Name: old})
+		}
+	}
+	for _, name := range added {
+		this.Stages = append(this.Stages, &StageChange{Type: ChangeAdded, Name: name})
+	}
+
+	// Order only matters between the stages both pipelines have.
+	order := func(p *Pipeline) map[string]int {
+		index := map[string]int{}
+		for _, stage := range p.Stages {
+			if _, ok := index[stage.Name]; common[stage.Name] && !ok {
+				index[stage.Name] = len(index)
+			}
+		}
+		return index
+	}
+	oldOrder, newOrder := order(a), order(b)
+	for _, stage := range b.Stages {
+		oldIndex, index := oldOrder[stage.Name], newOrder[stage.Name]
+		if !common[stage.Name] || oldIndex == index {
+			continue
+		}
+		this.Stages = append(this.Stages, &StageChange{
+			Type:     ChangeMoved,
+			Name:     stage.Name,
+			OldIndex: &oldIndex,
+			Index:    &index,
+		})
+	}
+}
+
+func (this *PipelineDiff) diffJobs(a, b *Pipeline) {
+	before, after := jobsByName(a), jobsByName(b)
+	removed, added := []string{}, []string{}
+
+	for _, name := range jobNames(a) {
+		old := before[name]
+		job, ok := after[name]
+		if !ok {
+			removed = append(removed, name)
+			continue
+		}
+
+		fields := diffFields(old.job, job.job, "Name", "Stage")
+		if old.stage != job.stage {
+			fields = append([]*FieldChange{{Field: "stage", Before: old.stage, After: job.stage}}, fields...)
+		}
+		if len(fields) > 0 {
+			this.Jobs = append(this.Jobs, &JobChange{Type: ChangeChanged, Name: name, Stage: job.stage, Fields: fields})
+		}
+	}
+	for _, name := range jobNames(b) {
+		if _, ok := before[name]; !ok {
+			added = append(added, name)
+		}
+	}
+
+	for _, old := range removed {
+		renamed := false
+		for i, name := range added {
+			if before[old].stage == after[name].stage && len(diffFields(before[old].job, after[name].job, "Name", "Stage")) == 0 {
+				this.Jobs = append(this.Jobs, &JobChange{Type: ChangeRenamed, Name: name, OldName: old, Stage: after[name].stage})
+				added = append(added[:i], added[i+1:]...)
+				renamed = true
+				break
+			}
+		}
+		if !renamed {
+			this.Jobs = append(this.Jobs, &JobChange{Type: ChangeRemoved, Name: old, Stage: before[old].stage})
+		}
+	}
+	for _, name := range added {
+		this.Jobs = append(this.Jobs, &JobChange{Type: ChangeAdded, Name: name, Stage: after[name].stage})
+	}
+}
+
+func (this *PipelineDiff) Empty() bool {
+	return len(this.Pipeline) == 0 && len(this.Stages) == 0 && len(this.Jobs) == 0
+}
+
+func (this *PipelineDiff) JSON() ([]byte, error) {
+	return json.MarshalIndent(this, "", "  ")
+}
+
+// String is a readable summary: + added, - removed, ~ changed, renamed or
+// moved, with the YAML of every changed field.
+func (this *PipelineDiff) String() string {
+	if this.Empty() {
+		return "No changes\n"
+	}
+
+	out := &strings.Builder{}
+	if len(this.Pipeline) > 0 {
+		out.WriteString("Pipeline:\n")
+		writeFields(out, this.Pipeline, "  ")
+	}
+
+	if len(this.Stages) > 0 {
+		out.WriteString("Stages:\n")
+		for _, stage := range this.Stages {
+			switch stage.Type {
+			case ChangeAdded:
+				fmt.Fprintf(out, "  + %s\n", stage.Name)
+			case ChangeRemoved:
+				fmt.Fprintf(out, "  - %s\n", stage.Name)
+			case ChangeRenamed:
+				fmt.Fprintf(out, "  ~ %s -> %s (renamed)\n", stage.OldName, stage.Name)
+			case ChangeMoved:
+				fmt.Fprintf(out, "  ~ %s (moved from %d to %d)\n", stage.Name, *stage.OldIndex+1, *stage.Index+1)
+			}
+		}
+	}
+
+	if len(this.Jobs) > 0 {
+		out.WriteString("Jobs:\n")
+		for _, job := range this.Jobs {
+			switch job.Type {
+			case ChangeAdded:
+				fmt.Fprintf(out, "  + %s (stage %s)\n", job.Name, job.Stage)
+			case ChangeRemoved:
+				fmt.Fprintf(out, "  - %s (stage %s)\n", job.Name, job.Stage)
+			case ChangeRenamed:
+				fmt.Fprintf(out, "  ~ %s -> %s (renamed)\n", job.OldName, job.Name)
+			case ChangeChanged:
+				fmt.Fprintf(out, "  ~ %s (stage %s)\n", job.Name, job.Stage)
+				writeFields(out, job.Fields, "      ")
+			}
+		}
+	}
+
+	return out.String()
+}
+
+func writeFields(out *strings.Builder, fields []*FieldChange, indent string) {
+	for _, field := range fields {
+		fmt.Fprintf(out, "%s%s:\n", indent, field.Field)
+		for _, line := range splitLines(field.Before) {
+			fmt.Fprintf(out, "%s  - %s\n", indent, line)
+		}
+		for _, line := range splitLines(field.After) {
+			fmt.Fprintf(out, "%s  + %s\n", indent, line)
+		}
+	}
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// diffFields compares the exported fields of two structs, or pointers to
+// structs, of the same type by their rendered YAML. Maps with string keys are
+// compared entry by entry.
+func diffFields(a, b any, skip ...string) []*FieldChange {
+	va, vb := reflect.Indirect(reflect.ValueOf(a)), reflect.Indirect(reflect.ValueOf(b))
+	changes := []*FieldChange{}
+
+	t := va.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := yamlName(field)
+		if !field.IsExported() || name == "-" || contains(skip, field.Name) {
+			continue
+		}
+		fa, fb := va.Field(i), vb.Field(i)
+
+		if field.Type.Kind() == reflect.Map && field.Type.Key().Kind() == reflect.String {
+			keys := map[string]bool{}
+			for _, key := range append(fa.MapKeys(), fb.MapKeys()...) {
+				keys[key.String()] = true
+			}
+			sorted := []string{}
+			for key := range keys {
+				sorted = append(sorted, key)
+			}
+			sort.Strings(sorted)
+
+			for _, key := range sorted {
+				before := yamlText(fa.MapIndex(reflect.ValueOf(key).Convert(field.Type.Key())))
+				after := yamlText(fb.MapIndex(reflect.ValueOf(key).Convert(field.Type.Key())))
+				if before != after {
+					changes = append(changes, &FieldChange{Field: name + "." + key, Before: before, After: after})
+				}
+			}
+			continue
+		}
+
+		if before, after := yamlText(fa), yamlText(fb); before != after {
+			changes = append(changes, &FieldChange{Field: name, Before: before, After: after})
+		}
+	}
+
+	return changes
+}
+
+// yamlText renders v the way it appears in the pipeline, empty when yaml.v3
+// would leave it out.
+func yamlText(v reflect.Value) string {
+	if !v.IsValid() {
+		return ""
+	}
+	if z, ok := v.Interface().(yaml.IsZeroer); ok {
+		if (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil() || z.IsZero() {
+			return ""
+		}
+	} else if v.IsZero() || ((v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.Len() == 0) {
+		return ""
+	}
+
+	out, err := yaml.Marshal(v.Interface())
+	if err != nil {
+		return fmt.Sprintf("%v", v.Interface())
+	}
+	return strings.TrimSuffix(string(out), "\n")
+}
+
+type stagedJob struct {
+	job   *Job
+	stage string
+}
+
+func jobsByName(p *Pipeline) map[string]stagedJob {
+	jobs := map[string]stagedJob{}
+	for _, stage := range p.Stages {
+		for _, job := range stage.Jobs {
+			if _, ok := jobs[job.Name]; !ok {
+				jobs[job.Name] = stagedJob{job, stage.Name}
+			}
+		}
+	}
+	return jobs
+}
+
+func jobNames(p *Pipeline) []string {
+	names := []string{}
+	for _, stage := range p.Stages {
+		for _, job := range stage.Jobs {
+			names = append(names, job.Name)
+		}
+	}
+	return names
+}
+
+func stageJobNames(p *Pipeline) map[string][]string {
+	stages := map[string][]string{}
+	for _, stage := range p.Stages {
+		names := []string{}
+		for _, job := range stage.Jobs {
+			names = append(names, job.Name)
+		}
+		stages[stage.Name] = append(stages[stage.Name], names...)
+	}
+	return stages
+}
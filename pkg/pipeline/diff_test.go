@@ -0,0 +1,147 @@
+package pipeline
+
+import "testing"
+
+func TestDiff(t *testing.T) {
+	const base = `
+stages: [build, test]
+variables:
+  A: a
+build:
+  stage: build
+  script: [make]
+test:
+  stage: test
+  script: [make test]
+`
+	tests := []struct {
+		name     string
+		a, b     string
+		nilA     bool
+		nilB     bool
+		pipeline []string
+		stages   []ChangeType
+		jobs     []ChangeType
+	}{
+		{
+			name: "identical",
+			a:    base,
+			b:    base,
+		},
+		{
+			name: "both nil",
+			nilA: true,
+			nilB: true,
+		},
+		{
+			name:     "nil before",
+			nilA:     true,
+			b:        base,
+			pipeline: []string{"variables.A"},
+			stages:   []ChangeType{ChangeAdded, ChangeAdded},
+			jobs:     []ChangeType{ChangeAdded, ChangeAdded},
+		},
+		{
+			name:     "nil after",
+			a:        base,
+			nilB:     true,
+			pipeline: []string{"variables.A"},
+			stages:   []ChangeType{ChangeRemoved, ChangeRemoved},
+			jobs:     []ChangeType{ChangeRemoved, ChangeRemoved},
+		},
+		{
+			name: "job changed",
+			a:    base,
+			b:    "stages: [build, test]\nvariables:\n  A: a\nbuild:\n  stage: build\n  script: [make all]\ntest:\n  stage: test\n  script: [make test]\n",
+			jobs: []ChangeType{ChangeChanged},
+		},
+		{
+			name: "job renamed",
+			a:    base,
+			b:    "stages: [build, test]\nvariables:\n  A: a\ncompile:\n  stage: build\n  script: [make]\ntest:\n  stage: test\n  script: [make test]\n",
+			jobs: []ChangeType{ChangeRenamed},
+		},
+		{
+			name:   "stage renamed",
+			a:      base,
+			b:      "stages: [compile, test]\nvariables:\n  A: a\nbuild:\n  stage: compile\n  script: [make]\ntest:\n  stage: test\n  script: [make test]\n",
+			stages: []ChangeType{ChangeRenamed},
+			jobs:   []ChangeType{ChangeChanged},
+		},
+		{
+			name:     "variable changed",
+			a:        base,
+			b:        "stages: [build, test]\nvariables:\n  A: b\nbuild:\n  stage: build\n  script: [make]\ntest:\n  stage: test\n  script: [make test]\n",
+			pipeline: []string{"variables.A"},
+		},
+	}
+
+	parse := func(t *testing.T, data string, isNil bool) *Pipeline {
+		if isNil {
+			return nil
+		}
+		p, err := Parse([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			diff := Diff(parse(t, test.a, test.nilA), parse(t, test.b, test.nilB))
+
+			fields := []string{}
+			for _, change := range diff.Pipeline {
+				fields = append(fields, change.Field)
+			}
+			if !equalStrings(fields, test.pipeline) {
+				t.Errorf("pipeline changes %v, want %v", fields, test.pipeline)
+			}
+
+			stages := []ChangeType{}
+			for _, change := range diff.Stages {
+				stages = append(stages, change.Type)
+			}
+			if !equalTypes(stages, test.stages) {
+				t.Errorf("stage changes %v, want %v", stages, test.stages)
+			}
+
+			jobs := []ChangeType{}
+			for _, change := range diff.Jobs {
+				jobs = append(jobs, change.Type)
+			}
+			if !equalTypes(jobs, test.jobs) {
+				t.Errorf("job changes %v, want %v", jobs, test.jobs)
+			}
+
+			if empty := test.pipeline == nil && test.stages == nil && test.jobs == nil; diff.Empty() != empty {
+				t.Errorf("Empty() = %v, want %v\n%s", diff.Empty(), empty, diff)
+			}
+		})
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalTypes(a, b []ChangeType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+package pipeline
+
+import "fmt"
+
+type (
+	Environment struct {
+		Name       string                 `yaml:",omitempty"`
+		Url        string                 `yaml:",omitempty"`
+		Action     string                 `yaml:",omitempty"`
+		Tier       string                 `yaml:"deployment_tier,omitempty"`
+		OnStop     string                 `yaml:"on_stop,omitempty"`
+		AutoStopIn string                 `yaml:"auto_stop_in,omitempty"`
+		Kubernetes *EnvironmentKubernetes `yaml:"kubernetes,omitempty"`
+	}
+	// Agent is the agent for Kubernetes to use, path/to/agent/project:agent-name
+	EnvironmentKubernetes struct {
+		Namespace string `yaml:"namespace,omitempty"`
+		Agent     string `yaml:"agent,omitempty"`
+	}
+)
+
+func (this *Job) SetEnvironment(name, action, url, tier string) {
+	this.Environment = Environment{
+		Name:   name,
+		Action: action,
+		Url:    url,
+		Tier:   tier,
+	}
+}
+
+// The job that stops the environment, see StopJob to create it.
+func (this *Job) SetOnStop(name string) {
+	this.Environment.OnStop = name
+}
+
+// DeployJob.SetAutoStopIn("1 week")
+func (this *Job) SetAutoStopIn(duration string) {
+	this.Environment.AutoStopIn = duration
+}
+
+func (this *Job) SetKubernetes(namespace, agent string) {
+	this.Environment.Kubernetes = &EnvironmentKubernetes{
+		Namespace: namespace,
+		Agent:     agent,
+	}
+}
+
+// StopJob creates the job that stops this job's environment and links it with
+// on_stop. The stop job is added to the same stage, uses the same environment
+// with action stop and copies the rules so both jobs are always created
+// together. The rules are copies, changing them leaves this job's rules
+// alone. Every rule but when never becomes when manual, so the stop job
+// never tears the environment down on its own. GIT_STRATEGY is none because
+// the branch may be gone by the time the environment is stopped. Add the
+// commands that tear the environment down to the returned job.
+//
+// StopReview := DeployReview.StopJob("Stop %s", environment)
+func (this *Job) StopJob(format string, a ...any) *Job {
+	name := fmt.Sprintf(format, a...)
+
+	var job *Job
+	if this.stage != nil {
+		job = this.stage.Job("%s", name)
+	} else {
+		job = NewJob("%s", name)
+		job.Stage = this.Stage
+	}
+
+	job.AddVariable("GIT_STRATEGY", "none")
+	job.Environment = Environment{
+		Name:   this.Environment.Name,
+		Action: "stop",
+	}
+	if kubernetes := this.Environment.Kubernetes; kubernetes != nil {
+		job.SetKubernetes(kubernetes.Namespace, kubernetes.Agent)
+	}
+
+	manual := "manual"
+	for _, rule := range this.Rules {
+		stop := rule.copy()
+		if stop.When == nil || *stop.When != "never" {
+			stop.When = &manual
+		}
+		job.Rules = append(job.Rules, stop)
+	}
+	if len(this.Rules) == 0 {
+		job.SetWhen(manual)
+	}
+
+	this.SetOnStop(job.Name)
+
+	return job
+}
@@ -0,0 +1,139 @@
+package pipeline
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestStopJob(t *testing.T) {
+	never, always := "never", "always"
+	condition := "$CI_MERGE_REQUEST_IID"
+
+	tests := []struct {
+		name      string
+		rules     []*JobRule
+		wantWhen  string
+		wantRules []string
+	}{
+		{name: "no rules", wantWhen: "manual", wantRules: []string{}},
+		{
+			name:      "rules",
+			rules:     []*JobRule{{If: &condition}, {If: &condition, When: &always}, {When: &never}},
+			wantRules: []string{"manual", "manual", "never"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := NewPipeline("test")
+			deploy := p.Stage("review").Job("Deploy review")
+			deploy.SetEnvironment("review/$CI_COMMIT_REF_SLUG", "start", "https://review.example.com", "")
+			deploy.SetKubernetes("review", "group/agents:review")
+			deploy.Rules = test.rules
+
+			stop := deploy.StopJob("Stop %s", "review")
+
+			if deploy.Environment.OnStop != "Stop review" {
+				t.Errorf("on_stop = %q, want Stop review", deploy.Environment.OnStop)
+			}
+			if len(p.Stages[0].Jobs) != 2 || p.Stages[0].Jobs[1] != stop {
+				t.Errorf("stop job is not in the deploy job's stage")
+			}
+			if stop.When != test.wantWhen {
+				t.Errorf("when = %q, want %q", stop.When, test.wantWhen)
+			}
+			if stop.Variables["GIT_STRATEGY"] != "none" {
+				t.Errorf("GIT_STRATEGY = %v, want none", stop.Variables["GIT_STRATEGY"])
+			}
+			want := Environment{
+				Name:       deploy.Environment.Name,
+				Action:     "stop",
+				Kubernetes: &EnvironmentKubernetes{Namespace: "review", Agent: "group/agents:review"},
+			}
+			if !reflect.DeepEqual(stop.Environment, want) {
+				t.Errorf("environment = %+v, want %+v", stop.Environment, want)
+			}
+
+			whens := []string{}
+			for _, rule := range stop.Rules {
+				whens = append(whens, *rule.When)
+			}
+			if !equalStrings(whens, test.wantRules) {
+				t.Errorf("rules when %v, want %v", whens, test.wantRules)
+			}
+		})
+	}
+}
+
+// Changing the stop job leaves the deploy job alone.
+func TestStopJobCopies(t *testing.T) {
+	condition, when := "$CI_MERGE_REQUEST_IID", "on_success"
+	allowFailure := false
+	deploy := NewJob("deploy")
+	deploy.SetEnvironment("review", "start", "", "")
+	deploy.SetKubernetes("review", "agent")
+	deploy.Rules = []*JobRule{{
+		If:           &condition,
+		When:         &when,
+		AllowFailure: &allowFailure,
+		Changes:      []string{"src/**"},
+		Exists:       []string{"Dockerfile"},
+		Variables:    map[string]string{"A": "a"},
+	}}
+
+	stop := deploy.StopJob("stop")
+	rule := stop.Rules[0]
+	*rule.If = "changed"
+	*rule.AllowFailure = true
+	rule.Changes[0] = "changed"
+	rule.Exists[0] = "changed"
+	rule.Variables["A"] = "changed"
+	stop.Environment.Kubernetes.Namespace = "changed"
+
+	original := deploy.Rules[0]
+	if *original.If != condition || *original.When != "on_success" || *original.AllowFailure ||
+		original.Changes[0] != "src/**" || original.Exists[0] != "Dockerfile" || original.Variables["A"] != "a" {
+		t.Errorf("deploy rule changed: %+v", original)
+	}
+	if deploy.Environment.Kubernetes.Namespace != "review" {
+		t.Errorf("deploy kubernetes changed: %+v", deploy.Environment.Kubernetes)
+	}
+}
+
+func TestValidateOnStop(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr []error
+	}{
+		{
+			name:    "unknown on_stop",
+			yaml:    "job:\n  environment:\n    name: review\n    on_stop: missing\n  script: [a]\n",
+			wantErr: []error{ErrUnknownJob},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := Parse([]byte(test.yaml))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = p.Validate()
+			errs := Errors{}
+			if err != nil && !errors.As(err, &errs) {
+				t.Fatalf("err = %v, want Errors", err)
+			}
+			if len(errs) != len(test.wantErr) {
+				t.Fatalf("got %d errors, want %d: %v", len(errs), len(test.wantErr), err)
+			}
+			for i, want := range test.wantErr {
+				if !errors.Is(errs[i], want) {
+					t.Errorf("error %d = %v, want %v", i, errs[i], want)
+				}
+			}
+		})
+	}
+}
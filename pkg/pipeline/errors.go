@@ -0,0 +1,87 @@
+package pipeline
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrDuplicateJob      = errors.New("duplicate job name")
+	ErrDuplicatePipeline = errors.New("duplicate pipeline name")
+	ErrReservedPipeline  = errors.New("pipeline name is the parent pipeline's file")
+	ErrOutputDir         = errors.New("output directory must be a clean relative path")
+)
+
+type (
+	// Error is a single problem found in a pipeline, with as much of its
+	// location as is known.
+	Error struct {
+		Pipeline string
+		Stage    string
+		Job      string
+		Err      error
+	}
+	// Errors collects every problem found in one pass over a pipeline or
+	// workflow.
+	Errors []*Error
+)
+
+func (this *Error) Error() string {
+	location := []string{}
+	if this.Pipeline != "" {
+		location = append(location, "pipeline "+this.Pipeline)
+	}
+	if this.Stage != "" {
+		location = append(location, "stage "+this.Stage)
+	}
+	if this.Job != "" {
+		location = append(location, "job "+this.Job)
+	}
+	if len(location) == 0 {
+		return this.Err.Error()
+	}
+	return strings.Join(location, ", ") + ": " + this.Err.Error()
+}
+
+func (this *Error) Unwrap() error {
+	return this.Err
+}
+
+func (this Errors) Error() string {
+	lines := make([]string, len(this))
+	for i, err := range this {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (this Errors) Unwrap() []error {
+	errs := make([]error, len(this))
+	for i, err := range this {
+		errs[i] = err
+	}
+	return errs
+}
+
+func (this *Errors) add(pipeline *Pipeline, stage *Stage, job *Job, err error) {
+	e := &Error{Err: err}
+	if pipeline != nil {
+		e.Pipeline = pipeline.Name
+	}
+	if stage != nil {
+		e.Stage = stage.Name
+	}
+	if job != nil {
+		e.Job = job.Name
+	}
+	*this = append(*this, e)
+}
+
+// err returns nil when nothing was collected, so callers never get a non-nil
+// error interface holding an empty list.
+func (this Errors) err() error {
+	if len(this) == 0 {
+		return nil
+	}
+	return this
+}
@@ -0,0 +1,110 @@
+package pipeline
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestErrorMessage(t *testing.T) {
+	err := errors.New("broken")
+	tests := []struct {
+		name string
+		err  *Error
+		want string
+	}{
+		{"no location", &Error{Err: err}, "broken"},
+		{"pipeline", &Error{Pipeline: "deploy", Err: err}, "pipeline deploy: broken"},
+		{"job", &Error{Pipeline: "deploy", Stage: "test", Job: "unit", Err: err}, "pipeline deploy, stage test, job unit: broken"},
+		{"template", &Error{Job: ".base", Err: err}, "job .base: broken"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.err.Error(); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRenderE(t *testing.T) {
+	tests := []struct {
+		name    string
+		build   func(p *Pipeline)
+		wantErr []error
+	}{
+		{
+			name: "valid",
+			build: func(p *Pipeline) {
+				p.Stage("test").Job("unit").AddCommand("go test")
+			},
+		},
+		{
+			name: "duplicate jobs",
+			build: func(p *Pipeline) {
+				p.Stage("build").Job("job")
+				p.Stage("test").Job("job")
+				p.Stage("deploy").Job("job")
+			},
+			wantErr: []error{ErrDuplicateJob, ErrDuplicateJob},
+		},
+		{
+			name: "duplicate template",
+			build: func(p *Pipeline) {
+				p.Template(".job")
+				p.Stage("test").Job(".job")
+			},
+			wantErr: []error{ErrDuplicateJob},
+		},
+		{
+			name: "unencodable values",
+			build: func(p *Pipeline) {
+				p.Stage("test").Job("a").AddVariable("F", func() {})
+				p.Stage("test").Job("b").AddVariable("C", make(chan int))
+			},
+			wantErr: []error{nil, nil},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := NewPipeline("test")
+			test.build(p)
+
+			out, err := p.RenderE()
+			if out == "" {
+				t.Error("rendered nothing")
+			}
+			errs := Errors{}
+			if err != nil && !errors.As(err, &errs) {
+				t.Fatalf("err = %v, want Errors", err)
+			}
+			if len(errs) != len(test.wantErr) {
+				t.Fatalf("got %d errors, want %d: %v", len(errs), len(test.wantErr), err)
+			}
+			for i, want := range test.wantErr {
+				if errs[i].Pipeline != "test" || errs[i].Job == "" {
+					t.Errorf("error %d has no location: %v", i, errs[i])
+				}
+				if want != nil && !errors.Is(errs[i], want) {
+					t.Errorf("error %d = %v, want %v", i, errs[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestWorkflowRenderE(t *testing.T) {
+	workflow := NewWorkflow()
+	workflow.CreatePipeline("deploy").Stage("test").Job("job")
+	workflow.CreatePipeline("deploy").Stage("test").Job("job").AddVariable("F", func() {})
+
+	_, err := workflow.RenderE()
+	if !errors.Is(err, ErrDuplicatePipeline) {
+		t.Errorf("err = %v, want %v", err, ErrDuplicatePipeline)
+	}
+	errs := Errors{}
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Errorf("err = %v, want 2 Errors", err)
+	}
+}
@@ -0,0 +1,300 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrExpression = errors.New("invalid expression")
+)
+
+type (
+	// operand is a value in a rules:if expression, a nil value is null.
+	operand struct {
+		value *string
+		regex *regexp.Regexp
+	}
+	expressionParser struct {
+		input string
+		pos   int
+		vars  map[string]string
+	}
+)
+
+// Evaluate evaluates a rules:if expression the way GitLab does: variables are
+// $NAME or ${NAME}, undefined variables are null, a lone operand is true when
+// it is a non empty string, && binds tighter than || and the right hand side
+// of =~ and !~ is a /regex/ literal or a variable holding one.
+func Evaluate(expression string, vars map[string]string) (bool, error) {
+	p := &expressionParser{input: expression, vars: vars}
+
+	result, err := p.or()
+	if err != nil {
+		return false, err
+	}
+	p.space()
+	if p.pos < len(p.input) {
+		return false, p.errorf("unexpected %q", p.input[p.pos:])
+	}
+	return result, nil
+}
+
+func (this *expressionParser) or() (bool, error) {
+	left, err := this.and()
+	if err != nil {
+		return false, err
+	}
+	for this.consume("||") {
+		right, err := this.and()
+		if err != nil {
+			return false, err
+		}
+		left = left || right
+	}
+	return left, nil
+}
+
+func (this *expressionParser) and() (bool, error) {
+	left, err := this.comparison()
+	if err != nil {
+		return false, err
+	}
+	for this.consume("&&") {
+		right, err := this.comparison()
+		if err != nil {
+			return false, err
+		}
+		left = left && right
+	}
+	return left, nil
+}
+
+func (this *expressionParser) comparison() (bool, error) {
+	if this.consume("(") {
+		result, err := this.or()
+		if err != nil {
+			return false, err
+		}
+		if !this.consume(")") {
+			return false, this.errorf("missing )")
+		}
+		return result, nil
+	}
+
+	left, err := this.operand()
+	if err != nil {
+		return false, err
+	}
+
+	for _, op := range []string{"==", "!=", "=~", "!~"} {
+		if !this.consume(op) {
+			continue
+		}
+		right, err := this.operand()
+		if err != nil {
+			return false, err
+		}
+
+		switch op {
+		case "==":
+			return left.equals(right), nil
+		case "!=":
+			return !left.equals(right), nil
+		}
+
+		re, err := right.pattern()
+		if err != nil {
+			return false, this.errorf("%v", err)
+		}
+		matched := left.value != nil && re.MatchString(*left.value)
+		if op == "=~" {
+			return matched, nil
+		}
+		return !matched, nil
+	}
+
+	return left.value != nil && *left.value != "", nil
+}
+
+func (this *expressionParser) operand() (*operand, error) {
+	this.space()
+	if this.pos >= len(this.input) {
+		return nil, this.errorf("missing operand")
+	}
+
+	rest := this.input[this.pos:]
+	switch c := rest[0]; {
+	case c == '$':
+		name := ""
+		if strings.HasPrefix(rest, "${") {
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				return nil, this.errorf("unterminated ${")
+			}
+			name = rest[2:end]
+			this.pos += end + 1
+		} else {
+			end := 1
+			for end < len(rest) && isVariableChar(rest[end]) {
+				end++
+			}
+			name = rest[1:end]
+			this.pos += end
+		}
+		if name == "" {
+			return nil, this.errorf("missing variable name")
+		}
+		if value, ok := this.vars[name]; ok {
+			return &operand{value: &value}, nil
+		}
+		return &operand{}, nil
+	case c == '"' || c == '\'':
+		end := 1
+		for end < len(rest) && rest[end] != c {
+			if rest[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(rest) {
+			return nil, this.errorf("unterminated string")
+		}
+		value := strings.ReplaceAll(rest[1:end], `\`+string(c), string(c))
+		this.pos += end + 1
+		return &operand{value: &value}, nil
+	case c == '/':
+		end := 1
+		for end < len(rest) && rest[end] != '/' {
+			if rest[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(rest) {
+			return nil, this.errorf("unterminated regex")
+		}
+		flags := end + 1
+		for flags < len(rest) && rest[flags] >= 'a' && rest[flags] <= 'z' {
+			flags++
+		}
+		re, err := compileRegex(rest[1:end], rest[end+1:flags])
+		if err != nil {
+			return nil, this.errorf("%v", err)
+		}
+		this.pos += flags
+		return &operand{regex: re}, nil
+	case strings.HasPrefix(rest, "null") && (len(rest) == 4 || !isVariableChar(rest[4])):
+		this.pos += 4
+		return &operand{}, nil
+	}
+
+	return nil, this.errorf("unexpected %q", rest)
+}
+
+func (this *operand) equals(other *operand) bool {
+	if this.value == nil || other.value == nil {
+		return this.value == nil && other.value == nil && this.regex == nil && other.regex == nil
+	}
+	return *this.value == *other.value
+}
+
+// pattern returns the regex literal, or compiles a variable or string holding
+// one.
+func (this *operand) pattern() (*regexp.Regexp, error) {
+	if this.regex != nil {
+		return this.regex, nil
+	}
+	if this.value == nil {
+		return nil, fmt.Errorf("right side of a pattern match is null")
+	}
+
+	value := *this.value
+	end := strings.LastIndexByte(value, '/')
+	if !strings.HasPrefix(value, "/") || end < 1 {
+		return nil, fmt.Errorf("%q is not a /regex/", value)
+	}
+	return compileRegex(value[1:end], value[end+1:])
+}
+
+// GitLab uses Ruby flags, m there makes . match newlines and x ignores
+// whitespace and # comments.
+func compileRegex(pattern, flags string) (*regexp.Regexp, error) {
+	prefix := ""
+	for _, flag := range flags {
+		switch flag {
+		case 'i':
+			prefix += "i"
+		case 'm':
+			prefix += "s"
+		case 'x':
+			pattern = extendedRegex(pattern)
+		default:
+			return nil, fmt.Errorf("unsupported regex flag %q", flag)
+		}
+	}
+	if prefix != "" {
+		pattern = "(?" + prefix + ")" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
+// extendedRegex drops the whitespace and # comments of an extended mode
+// pattern, except escaped or inside a character class.
+func extendedRegex(pattern string) string {
+	out := strings.Builder{}
+	class := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			if pattern[i] == ' ' {
+				// RE2 has no \<space>, a plain space is the same.
+				out.WriteByte(' ')
+			} else {
+				out.WriteByte(c)
+				out.WriteByte(pattern[i])
+			}
+			continue
+		case class:
+			class = c != ']'
+		case c == '[':
+			class = true
+		case strings.IndexByte(" \t\r\n\f\v", c) >= 0:
+			continue
+		case c == '#':
+			for i < len(pattern) && pattern[i] != '\n' {
+				i++
+			}
+			continue
+		}
+		out.WriteByte(c)
+	}
+	return out.String()
+}
+
+func (this *expressionParser) consume(token string) bool {
+	this.space()
+	if strings.HasPrefix(this.input[this.pos:], token) {
+		this.pos += len(token)
+		return true
+	}
+	return false
+}
+
+func (this *expressionParser) space() {
+	for this.pos < len(this.input) && strings.ContainsRune(" \t\r\n", rune(this.input[this.pos])) {
+		this.pos++
+	}
+}
+
+func (this *expressionParser) errorf(format string, a ...any) error {
+	return fmt.Errorf("%w %q at %d: %s", ErrExpression, this.input, this.pos, fmt.Sprintf(format, a...))
+}
+
+func isVariableChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,93 @@
+package pipeline
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	vars := map[string]string{
+		"CI_COMMIT_BRANCH":        "main",
+		"CI_DEFAULT_BRANCH":       "main",
+		"CI_PIPELINE_SOURCE":      "merge_request_event",
+		"CI_COMMIT_TAG":           "v1.2.3",
+		"CI_COMMIT_MESSAGE":       "Fix build\n\n[skip tests]",
+		"EMPTY":                   "",
+		"PATTERN":                 "/^v\\d+/",
+		"NOT_A_PATTERN":           "v1",
+		"CI_MERGE_REQUEST_LABELS": "bug,Deploy",
+		"QUOTED":                  `say "hi"`,
+	}
+
+	tests := []struct {
+		expression string
+		want       bool
+		wantErr    bool
+	}{
+		{expression: `$CI_COMMIT_BRANCH`, want: true},
+		{expression: `$EMPTY`, want: false},
+		{expression: `$UNDEFINED`, want: false},
+		{expression: `${CI_COMMIT_BRANCH}`, want: true},
+		{expression: `$CI_COMMIT_BRANCH == "main"`, want: true},
+		{expression: `$CI_COMMIT_BRANCH == 'main'`, want: true},
+		{expression: `$CI_COMMIT_BRANCH != "main"`, want: false},
+		{expression: `$CI_COMMIT_BRANCH == $CI_DEFAULT_BRANCH`, want: true},
+		{expression: `$UNDEFINED == null`, want: true},
+		{expression: `$EMPTY == null`, want: false},
+		{expression: `$EMPTY == ""`, want: true},
+		{expression: `$UNDEFINED != null`, want: false},
+		{expression: `$QUOTED == "say \"hi\""`, want: true},
+		{expression: `$CI_COMMIT_TAG =~ /^v\d+\.\d+\.\d+$/`, want: true},
+		{expression: `$CI_COMMIT_TAG !~ /^v\d+/`, want: false},
+		{expression: `$CI_COMMIT_TAG =~ $PATTERN`, want: true},
+		{expression: `$UNDEFINED =~ /.*/`, want: false},
+		{expression: `$CI_MERGE_REQUEST_LABELS =~ /deploy/`, want: false},
+		{expression: `$CI_MERGE_REQUEST_LABELS =~ /deploy/i`, want: true},
+		{expression: `$CI_COMMIT_MESSAGE =~ /build.*skip/`, want: false},
+		{expression: `$CI_COMMIT_MESSAGE =~ /build.*skip/m`, want: true},
+		{expression: `$CI_COMMIT_TAG =~ /^ v \d+ \. \d+ \. \d+ $/x`, want: true},
+		{expression: `$CI_COMMIT_TAG =~ /^v1 # the major version/x`, want: true},
+		{expression: `$CI_COMMIT_TAG =~ /^v2 # v1/x`, want: false},
+		{expression: `$QUOTED =~ /say "hi"/x`, want: false},
+		{expression: `$QUOTED =~ /say\ "hi"/x`, want: true},
+		{expression: `$QUOTED =~ /say[ ]"hi"/x`, want: true},
+		{expression: `$CI_MERGE_REQUEST_LABELS =~ /d e p l o y/ix`, want: true},
+		{expression: `$CI_COMMIT_BRANCH && $CI_COMMIT_TAG`, want: true},
+		{expression: `$CI_COMMIT_BRANCH && $UNDEFINED`, want: false},
+		{expression: `$UNDEFINED || $CI_COMMIT_TAG`, want: true},
+		// && binds tighter than ||.
+		{expression: `$CI_COMMIT_BRANCH || $UNDEFINED && $UNDEFINED`, want: true},
+		{expression: `($CI_COMMIT_BRANCH || $UNDEFINED) && $UNDEFINED`, want: false},
+		{expression: `$CI_PIPELINE_SOURCE == "merge_request_event" || ($CI_COMMIT_BRANCH == $CI_DEFAULT_BRANCH && $CI_COMMIT_TAG == null)`, want: true},
+		{expression: `  $CI_COMMIT_BRANCH   ==   "main"  `, want: true},
+		{expression: `$CI_COMMIT_TAG =~ $NOT_A_PATTERN`, wantErr: true},
+		{expression: `$CI_COMMIT_TAG =~ $UNDEFINED`, wantErr: true},
+		{expression: `$CI_COMMIT_BRANCH ==`, wantErr: true},
+		{expression: `($CI_COMMIT_BRANCH`, wantErr: true},
+		{expression: `$CI_COMMIT_BRANCH == "main`, wantErr: true},
+		{expression: `$CI_COMMIT_TAG =~ /v`, wantErr: true},
+		{expression: `$CI_COMMIT_TAG =~ /v/q`, wantErr: true},
+		{expression: `${CI_COMMIT_BRANCH`, wantErr: true},
+		{expression: `$`, wantErr: true},
+		{expression: `main`, wantErr: true},
+		{expression: `$CI_COMMIT_BRANCH main`, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			got, err := Evaluate(test.expression, vars)
+			if test.wantErr {
+				if !errors.Is(err, ErrExpression) {
+					t.Fatalf("err = %v, want %v", err, ErrExpression)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
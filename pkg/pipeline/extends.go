@@ -0,0 +1,133 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MaxExtendsDepth is how many levels of extends GitLab follows.
+const MaxExtendsDepth = 11
+
+var (
+	ErrUnknownParent = errors.New("extends unknown job")
+	ErrCyclicExtends = errors.New("extends cycle")
+	ErrExtendsDepth  = errors.New("extends nested too deep")
+)
+
+// Effective returns a copy of job with everything it extends merged in, the
+// way GitLab does: parents in the order listed, each resolved first and
+// merged over the ones before, and job itself last. Mappings, such as
+// variables, are merged key by key, anything else, lists included, is
+// replaced. Parents are templates or other jobs of this pipeline. Every
+// !reference is replaced by what it points at.
+func (this *Pipeline) Effective(job *Job) (*Job, error) {
+	node, err := this.extend(job, []string{job.Name})
+	if err != nil {
+		return nil, err
+	}
+	node, err = this.dereference(node, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	effective := NewJob("%s", job.Name)
+	if err := node.Decode(effective); err != nil {
+		return nil, err
+	}
+	effective.Name = job.Name
+	effective.stage = job.stage
+	if job.stage != nil {
+		effective.Stage = job.stage.Name
+	}
+	return effective, nil
+}
+
+// Flatten returns a copy of the pipeline with every job replaced by its
+// Effective job and without templates, which is what GitLab runs. Jobs that
+// can't be resolved are kept as they are and reported in Errors.
+func (this *Pipeline) Flatten() (*Pipeline, error) {
+	errs := Errors{}
+	flat := *this
+	flat.Templates = []*Job{}
+	flat.Stages = []*Stage{}
+
+	for _, stage := range this.Stages {
+		copied := &Stage{pipeline: &flat, Name: stage.Name, Jobs: []*Job{}}
+		flat.Stages = append(flat.Stages, copied)
+
+		for _, job := range stage.Jobs {
+			effective, err := this.Effective(job)
+			if err != nil {
+				errs.add(this, stage, job, err)
+				c := *job
+				effective = &c
+			}
+			effective.stage = copied
+			copied.Jobs = append(copied.Jobs, effective)
+		}
+	}
+
+	return &flat, errs.err()
+}
+
+// extend returns job as a YAML mapping with its parents merged in. chain is
+// the path of extends that led to job, job included.
+func (this *Pipeline) extend(job *Job, chain []string) (*yaml.Node, error) {
+	if len(chain)-1 > MaxExtendsDepth {
+		return nil, fmt.Errorf("%w: %s", ErrExtendsDepth, strings.Join(chain, " -> "))
+	}
+
+	own, err := encode(job)
+	if err != nil {
+		return nil, err
+	}
+	// A merged template can bring extends along.
+	extends := []string{}
+	if node := getKey(own, "extends"); node != nil {
+		if err := toList(node).Decode(&extends); err != nil {
+			return nil, err
+		}
+	}
+
+	merged := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, name := range extends {
+		next := append(append([]string{}, chain...), name)
+		if contains(chain, name) {
+			return nil, fmt.Errorf("%w: %s", ErrCyclicExtends, strings.Join(next, " -> "))
+		}
+		parent := this.lookup(name)
+		if parent == nil {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownParent, name)
+		}
+
+		node, err := this.extend(parent, next)
+		if err != nil {
+			return nil, err
+		}
+		merged = deepMerge(merged, node)
+	}
+
+	merged = deepMerge(merged, own)
+	setKey(merged, "extends", nil)
+	return merged, nil
+}
+
+// lookup finds a template or job by name, templates first.
+func (this *Pipeline) lookup(name string) *Job {
+	for _, template := range this.Templates {
+		if template.Name == name {
+			return template
+		}
+	}
+	for _, stage := range this.Stages {
+		for _, job := range stage.Jobs {
+			if job.Name == name {
+				return job
+			}
+		}
+	}
+	return nil
+}
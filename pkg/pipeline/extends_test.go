@@ -0,0 +1,308 @@
+package pipeline
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestEffective(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		job     string
+		check   func(t *testing.T, job *Job)
+		wantErr error
+	}{
+		{
+			name: "variables merge, lists replace",
+			yaml: `
+.base:
+  variables: {A: base, B: base}
+  script: [base]
+  tags: [docker]
+job:
+  extends: .base
+  variables: {B: job}
+  script: [job]
+`,
+			job: "job",
+			check: func(t *testing.T, job *Job) {
+				want := map[string]any{"A": "base", "B": "job"}
+				if !reflect.DeepEqual(job.Variables, want) {
+					t.Errorf("variables %v, want %v", job.Variables, want)
+				}
+				if !reflect.DeepEqual(job.Script, []string{"job"}) {
+					t.Errorf("script %v, want [job]", job.Script)
+				}
+				if !reflect.DeepEqual(job.Tags, []string{"docker"}) {
+					t.Errorf("tags %v, want [docker]", job.Tags)
+				}
+			},
+		},
+		{
+			name: "parents in order",
+			yaml: `
+.a:
+  image: a
+  variables: {A: a, C: a}
+.b:
+  image: b
+  variables: {B: b, C: b}
+job:
+  extends: [.a, .b]
+  script: [job]
+`,
+			job: "job",
+			check: func(t *testing.T, job *Job) {
+				if job.Image == nil || job.Image.Name != "b" {
+					t.Errorf("image %v, want b", job.Image)
+				}
+				want := map[string]any{"A": "a", "B": "b", "C": "b"}
+				if !reflect.DeepEqual(job.Variables, want) {
+					t.Errorf("variables %v, want %v", job.Variables, want)
+				}
+			},
+		},
+		{
+			name: "chain",
+			yaml: `
+.a:
+  variables: {A: a}
+.b:
+  extends: .a
+  variables: {B: b}
+job:
+  extends: .b
+  script: [job]
+`,
+			job: "job",
+			check: func(t *testing.T, job *Job) {
+				want := map[string]any{"A": "a", "B": "b"}
+				if !reflect.DeepEqual(job.Variables, want) {
+					t.Errorf("variables %v, want %v", job.Variables, want)
+				}
+				if len(job.Extends) != 0 {
+					t.Errorf("extends %v left over", job.Extends)
+				}
+			},
+		},
+		{
+			name: "false and zero override the parent",
+			yaml: `
+.base:
+  allow_failure: true
+  retry: 2
+  interruptible: true
+job:
+  extends: .base
+  allow_failure: false
+  retry: 0
+  interruptible: false
+  script: [job]
+`,
+			job: "job",
+			check: func(t *testing.T, job *Job) {
+				if job.AllowFailure || !job.allowFailureSet() {
+					t.Errorf("allow_failure %v, want an explicit false", job.AllowFailure)
+				}
+				if job.Retry != 0 || !job.zeros["retry"] {
+					t.Errorf("retry %v, want an explicit 0", job.Retry)
+				}
+				if job.Interruptible == nil || *job.Interruptible {
+					t.Errorf("interruptible %v, want false", job.Interruptible)
+				}
+			},
+		},
+		{
+			name: "inherited when not set",
+			yaml: `
+.base:
+  allow_failure: true
+  retry: 2
+job:
+  extends: .base
+  script: [job]
+`,
+			job: "job",
+			check: func(t *testing.T, job *Job) {
+				if !job.AllowFailure {
+					t.Errorf("allow_failure %v, want true", job.AllowFailure)
+				}
+				if job.Retry != 2 {
+					t.Errorf("retry %v, want 2", job.Retry)
+				}
+			},
+		},
+		{
+			name: "extends another job",
+			yaml: `
+build:
+  stage: build
+  image: golang
+  script: [go build]
+test:
+  extends: build
+  stage: test
+  script: [go test]
+`,
+			job: "test",
+			check: func(t *testing.T, job *Job) {
+				if job.Image == nil || job.Image.Name != "golang" {
+					t.Errorf("image %v, want golang", job.Image)
+				}
+				if job.Stage != "test" {
+					t.Errorf("stage %q, want test", job.Stage)
+				}
+			},
+		},
+		{
+			name: "reference",
+			yaml: `
+.setup:
+  script: [setup]
+job:
+  script:
+    - !reference [.setup, script]
+    - job
+`,
+			job: "job",
+			check: func(t *testing.T, job *Job) {
+				if !reflect.DeepEqual(job.Script, []string{"setup", "job"}) {
+					t.Errorf("script %v, want [setup job]", job.Script)
+				}
+			},
+		},
+		{
+			name:    "unknown parent",
+			yaml:    "job:\n  extends: .missing\n  script: [job]\n",
+			job:     "job",
+			wantErr: ErrUnknownParent,
+		},
+		{
+			name:    "cycle",
+			yaml:    ".a:\n  extends: .b\n.b:\n  extends: .a\njob:\n  extends: .a\n  script: [job]\n",
+			job:     "job",
+			wantErr: ErrCyclicExtends,
+		},
+		{
+			name: "too deep",
+			yaml: `
+.l0: {variables: {A: a}}
+.l1: {extends: .l0}
+.l2: {extends: .l1}
+.l3: {extends: .l2}
+.l4: {extends: .l3}
+.l5: {extends: .l4}
+.l6: {extends: .l5}
+.l7: {extends: .l6}
+.l8: {extends: .l7}
+.l9: {extends: .l8}
+.l10: {extends: .l9}
+.l11: {extends: .l10}
+job:
+  extends: .l11
+  script: [job]
+`,
+			job:     "job",
+			wantErr: ErrExtendsDepth,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := Parse([]byte(test.yaml))
+			if err != nil {
+				t.Fatal(err)
+			}
+			job := p.lookup(test.job)
+			if job == nil {
+				t.Fatalf("no job %s", test.job)
+			}
+
+			effective, err := p.Effective(job)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("err = %v, want %v", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.check(t, effective)
+		})
+	}
+}
+
+// A job whose parent is missing is reported, the others are still simulated.
+func TestSimulateUnknownParent(t *testing.T) {
+	p, err := Parse([]byte(`
+broken:
+  extends: .from-an-include
+  script: [broken]
+fine:
+  script: [fine]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sim, err := p.SimulateFS(fstest.MapFS{}, nil, nil)
+	if !errors.Is(err, ErrUnknownParent) {
+		t.Fatalf("err = %v, want %v", err, ErrUnknownParent)
+	}
+	if sim == nil {
+		t.Fatal("no simulation")
+	}
+	names := []string{}
+	for _, job := range sim.Jobs {
+		names = append(names, job.Name)
+	}
+	if !equalStrings(names, []string{"broken", "fine"}) {
+		t.Errorf("jobs %v, want [broken fine]", names)
+	}
+}
+
+// SetAllowFailure(false) and SetRetry(0) render, override the parent and
+// override the allow_failure manual jobs get by default.
+func TestExplicitZeros(t *testing.T) {
+	p := NewPipeline("zeros")
+	base := p.Template(".base")
+	base.SetAllowFailure(true)
+	base.SetRetry(2)
+	job := p.Stage("test").Job("job")
+	job.Extend(".base")
+	job.SetWhen("manual")
+	job.SetAllowFailure(false)
+	job.SetRetry(0)
+	job.AddCommand("true")
+
+	node, err := encode(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := getKey(node, "allow_failure"); value == nil || value.Value != "false" || value.ShortTag() != "!!bool" {
+		t.Errorf("allow_failure renders as %v, want false", value)
+	}
+	if value := getKey(node, "retry"); value == nil || value.Value != "0" || value.ShortTag() != "!!int" {
+		t.Errorf("retry renders as %v, want 0", value)
+	}
+
+	effective, err := p.Effective(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if effective.AllowFailure || effective.Retry != 0 {
+		t.Errorf("allow_failure %v, retry %d, want false and 0", effective.AllowFailure, effective.Retry)
+	}
+
+	sim, err := p.SimulateFS(fstest.MapFS{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sim.Jobs) != 1 || sim.Jobs[0].AllowFailure {
+		t.Errorf("simulated %+v, want job not allowed to fail", sim.Jobs)
+	}
+}
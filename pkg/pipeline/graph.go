@@ -0,0 +1,240 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	edgeNeeds edgeKind = iota
+	edgeDependency
+	edgeTrigger
+)
+
+type (
+	edgeKind int
+	// graph is the drawing shared by the DOT and Mermaid writers: clusters
+	// of nodes, possibly nested, and the edges between nodes or clusters.
+	graph struct {
+		clusters []*cluster
+		edges    []*edge
+	}
+	cluster struct {
+		id       string
+		label    string
+		nodes    []*node
+		clusters []*cluster
+	}
+	node struct {
+		id    string
+		label string
+	}
+	edge struct {
+		from string
+		to   string
+		kind edgeKind
+		// DOT can't point an edge at a cluster, it points at a node inside
+		// and clips the edge at the cluster with lhead.
+		toCluster string
+	}
+)
+
+// DOT renders the pipeline as a Graphviz digraph: stages are clusters, jobs
+// are nodes, needs are solid edges and dependencies dashed edges.
+func (this *Pipeline) DOT() string {
+	return this.graph("").dot(this.Name)
+}
+
+// Mermaid renders the pipeline as a Mermaid flowchart, laid out like DOT.
+func (this *Pipeline) Mermaid() string {
+	return this.graph("").mermaid()
+}
+
+// DOT renders the parent pipeline and every child pipeline, with the trigger
+// jobs pointing at the child pipelines they start.
+func (this *Workflow) DOT() string {
+	return this.graph().dot("workflow")
+}
+
+func (this *Workflow) Mermaid() string {
+	return this.graph().mermaid()
+}
+
+func (this *Pipeline) graph(prefix string) *graph {
+	g := &graph{}
+	ids := map[string]string{}
+
+	for i, stage := range this.Stages {
+		c := &cluster{
+			id:    fmt.Sprintf("%ss%d", prefix, i),
+			label: stage.Name,
+		}
+		for j, job := range stage.Jobs {
+			n := &node{
+				id:    fmt.Sprintf("%ss%dj%d", prefix, i, j),
+				label: job.Name,
+			}
+			c.nodes = append(c.nodes, n)
+			ids[job.Name] = n.id
+			for _, instance := range job.ExpandParallel() {
+				ids[instance.Name] = n.id
+			}
+		}
+		g.clusters = append(g.clusters, c)
+	}
+
+	for _, stage := range this.Stages {
+		for _, job := range stage.Jobs {
+			for _, need := range job.allNeeds() {
+				if from, ok := ids[need.Job]; ok && need.Project == "" && need.Pipeline == "" {
+					g.edges = append(g.edges, &edge{from: from, to: ids[job.Name], kind: edgeNeeds})
+				}
+			}
+			for _, dependency := range job.Dependencies {
+				if from, ok := ids[dependency]; ok {
+					g.edges = append(g.edges, &edge{from: from, to: ids[job.Name], kind: edgeDependency})
+				}
+			}
+		}
+	}
+
+	return g
+}
+
+func (this *Workflow) graph() *graph {
+	g := &graph{}
+	parent := &cluster{id: "parent", label: "parent"}
+	generate := &node{id: "generate", label: "generate"}
+	parent.nodes = append(parent.nodes, generate)
+	g.clusters = append(g.clusters, parent)
+
+	for i, pipeline := range this.Pipelines {
+		prefix := fmt.Sprintf("p%d", i)
+		child := pipeline.graph(prefix)
+
+		c := &cluster{
+			id:       prefix,
+			label:    pipeline.Name,
+			clusters: child.clusters,
+		}
+		// An empty child pipeline still needs a node for the trigger edge
+		// to land on.
+		if c.first() == nil {
+			c.nodes = append(c.nodes, &node{id: prefix + "empty", label: "(no jobs)"})
+		}
+		g.clusters = append(g.clusters, c)
+		g.edges = append(g.edges, child.edges...)
+
+		trigger := &node{id: prefix + "trigger", label: "Trigger " + pipeline.Name}
+		parent.nodes = append(parent.nodes, trigger)
+		g.edges = append(g.edges,
+			&edge{from: generate.id, to: trigger.id, kind: edgeDependency},
+			&edge{from: trigger.id, to: c.first().id, toCluster: c.id, kind: edgeTrigger},
+		)
+	}
+
+	return g
+}
+
+func (this *cluster) first() *node {
+	if len(this.nodes) > 0 {
+		return this.nodes[0]
+	}
+	for _, c := range this.clusters {
+		if n := c.first(); n != nil {
+			return n
+		}
+	}
+	return nil
+}
+
+func (this *graph) dot(name string) string {
+	out := &strings.Builder{}
+	fmt.Fprintf(out, "digraph %s {\n", dotQuote(name))
+	out.WriteString("\trankdir=LR;\n")
+	out.WriteString("\tcompound=true;\n")
+	out.WriteString("\tnode [shape=box, style=rounded];\n")
+
+	var write func(c *cluster, indent string)
+	write = func(c *cluster, indent string) {
+		fmt.Fprintf(out, "%ssubgraph cluster_%s {\n", indent, c.id)
+		fmt.Fprintf(out, "%s\tlabel=%s;\n", indent, dotQuote(c.label))
+		for _, n := range c.nodes {
+			fmt.Fprintf(out, "%s\t%s [label=%s];\n", indent, n.id, dotQuote(n.label))
+		}
+		for _, child := range c.clusters {
+			write(child, indent+"\t")
+		}
+		fmt.Fprintf(out, "%s}\n", indent)
+	}
+	for _, c := range this.clusters {
+		write(c, "\t")
+	}
+
+	for _, e := range this.edges {
+		attrs := []string{}
+		switch e.kind {
+		case edgeDependency:
+			attrs = append(attrs, "style=dashed")
+		case edgeTrigger:
+			attrs = append(attrs, "style=bold")
+		}
+		if e.toCluster != "" {
+			attrs = append(attrs, "lhead=cluster_"+e.toCluster)
+		}
+		if len(attrs) > 0 {
+			fmt.Fprintf(out, "\t%s -> %s [%s];\n", e.from, e.to, strings.Join(attrs, ", "))
+		} else {
+			fmt.Fprintf(out, "\t%s -> %s;\n", e.from, e.to)
+		}
+	}
+
+	out.WriteString("}\n")
+	return out.String()
+}
+
+func (this *graph) mermaid() string {
+	out := &strings.Builder{}
+	out.WriteString("flowchart LR\n")
+
+	var write func(c *cluster, indent string)
+	write = func(c *cluster, indent string) {
+		fmt.Fprintf(out, "%ssubgraph %s[%s]\n", indent, c.id, mermaidQuote(c.label))
+		for _, n := range c.nodes {
+			fmt.Fprintf(out, "%s\t%s[%s]\n", indent, n.id, mermaidQuote(n.label))
+		}
+		for _, child := range c.clusters {
+			write(child, indent+"\t")
+		}
+		fmt.Fprintf(out, "%send\n", indent)
+	}
+	for _, c := range this.clusters {
+		write(c, "\t")
+	}
+
+	for _, e := range this.edges {
+		switch e.kind {
+		case edgeNeeds:
+			fmt.Fprintf(out, "\t%s --> %s\n", e.from, e.to)
+		case edgeDependency:
+			fmt.Fprintf(out, "\t%s -.-> %s\n", e.from, e.to)
+		case edgeTrigger:
+			to := e.to
+			if e.toCluster != "" {
+				to = e.toCluster
+			}
+			fmt.Fprintf(out, "\t%s ==> %s\n", e.from, to)
+		}
+	}
+
+	return out.String()
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// Mermaid labels are quoted strings where quotes are written as entities.
+func mermaidQuote(s string) string {
+	return `"` + strings.NewReplacer(`"`, "#quot;", "\n", " ").Replace(s) + `"`
+}
@@ -0,0 +1,167 @@
+package pipeline
+
+import "testing"
+
+func graphPipeline() *Pipeline {
+	p := NewPipeline(`my "app"`)
+	buildStage := p.Stage("build")
+	build := buildStage.Job(`build "app"`)
+	lint := buildStage.Job("lint")
+	lint.SetParallel(2)
+	unit := p.Stage("test").Job("unit tests")
+	unit.NeedsJob(build)
+	unit.Need("lint 2/2")
+	unit.Dependency(`build "app"`)
+	deploy := p.Stage("deploy").Job(`deploy\prod`)
+	deploy.DetailedNeeds = append(deploy.DetailedNeeds, &JobNeed{Project: "group/other", Job: "build", Ref: "main"})
+	deploy.Need("missing")
+	return p
+}
+
+func TestPipelineGraph(t *testing.T) {
+	tests := []struct {
+		name   string
+		render func(p *Pipeline) string
+		want   string
+	}{
+		{
+			name:   "dot",
+			render: (*Pipeline).DOT,
+			want: `digraph "my \"app\"" {
+	rankdir=LR;
+	compound=true;
+	node [shape=box, style=rounded];
+	subgraph cluster_s0 {
+		label="build";
+		s0j0 [label="build \"app\""];
+		s0j1 [label="lint"];
+	}
+	subgraph cluster_s1 {
+		label="test";
+		s1j0 [label="unit tests"];
+	}
+	subgraph cluster_s2 {
+		label="deploy";
+		s2j0 [label="deploy\\prod"];
+	}
+	s0j0 -> s1j0;
+	s0j1 -> s1j0;
+	s0j0 -> s1j0 [style=dashed];
+}
+`,
+		},
+		{
+			name:   "mermaid",
+			render: (*Pipeline).Mermaid,
+			want: `flowchart LR
+	subgraph s0["build"]
+		s0j0["build #quot;app#quot;"]
+		s0j1["lint"]
+	end
+	subgraph s1["test"]
+		s1j0["unit tests"]
+	end
+	subgraph s2["deploy"]
+		s2j0["deploy\prod"]
+	end
+	s0j0 --> s1j0
+	s0j1 --> s1j0
+	s0j0 -.-> s1j0
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.render(graphPipeline()); got != test.want {
+				t.Errorf("got\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWorkflowGraph(t *testing.T) {
+	workflow := NewWorkflow()
+	child := workflow.CreatePipeline("deploy app")
+	plan := child.Stage("plan").Job("plan")
+	child.Stage("apply").Job("apply").NeedsJob(plan)
+	workflow.CreatePipeline("empty")
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{
+			name: "dot",
+			got:  workflow.DOT(),
+			want: `digraph "workflow" {
+	rankdir=LR;
+	compound=true;
+	node [shape=box, style=rounded];
+	subgraph cluster_parent {
+		label="parent";
+		generate [label="generate"];
+		p0trigger [label="Trigger deploy app"];
+		p1trigger [label="Trigger empty"];
+	}
+	subgraph cluster_p0 {
+		label="deploy app";
+		subgraph cluster_p0s0 {
+			label="plan";
+			p0s0j0 [label="plan"];
+		}
+		subgraph cluster_p0s1 {
+			label="apply";
+			p0s1j0 [label="apply"];
+		}
+	}
+	subgraph cluster_p1 {
+		label="empty";
+		p1empty [label="(no jobs)"];
+	}
+	p0s0j0 -> p0s1j0;
+	generate -> p0trigger [style=dashed];
+	p0trigger -> p0s0j0 [style=bold, lhead=cluster_p0];
+	generate -> p1trigger [style=dashed];
+	p1trigger -> p1empty [style=bold, lhead=cluster_p1];
+}
+`,
+		},
+		{
+			name: "mermaid",
+			got:  workflow.Mermaid(),
+			want: `flowchart LR
+	subgraph parent["parent"]
+		generate["generate"]
+		p0trigger["Trigger deploy app"]
+		p1trigger["Trigger empty"]
+	end
+	subgraph p0["deploy app"]
+		subgraph p0s0["plan"]
+			p0s0j0["plan"]
+		end
+		subgraph p0s1["apply"]
+			p0s1j0["apply"]
+		end
+	end
+	subgraph p1["empty"]
+		p1empty["(no jobs)"]
+	end
+	p0s0j0 --> p0s1j0
+	generate -.-> p0trigger
+	p0trigger ==> p0
+	generate -.-> p1trigger
+	p1trigger ==> p1
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.got != test.want {
+				t.Errorf("got\n%s\nwant\n%s", test.got, test.want)
+			}
+		})
+	}
+}
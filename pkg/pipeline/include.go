@@ -0,0 +1,384 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/reflexias/gitlab-tools/internal/glob"
+	"github.com/reflexias/gitlab-tools/pkg/getfile"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// MaxIncludes is how many files GitLab includes in one pipeline.
+	MaxIncludes = 150
+	// MaxIncludeDepth is how deep GitLab nests includes.
+	MaxIncludeDepth = 100
+	// Where GitLab keeps the templates include:template names.
+	TemplateProject = "gitlab-org/gitlab"
+	TemplateRef     = "master"
+	TemplateDir     = "lib/gitlab/ci/templates"
+	// RemoteTimeout limits downloading one include:remote file.
+	RemoteTimeout = 30 * time.Second
+	// MaxRemoteSize limits the size of one include:remote file, in bytes.
+	MaxRemoteSize = 4 << 20
+)
+
+var (
+	ErrTooManyIncludes = errors.New("too many includes")
+	ErrUnsupported     = errors.New("unsupported include")
+	ErrRemoteTooLarge  = errors.New("remote include too large")
+)
+
+type (
+	// IncludeResolver merges a pipeline with everything it includes, the way
+	// GitLab does before it runs anything.
+	IncludeResolver struct {
+		source          getfile.Source
+		project         string
+		ref             string
+		templates       getfile.Source
+		templateProject string
+		templateRef     string
+		client          *http.Client
+		variables       map[string]string
+	}
+	// includeFile is where an included file comes from, local includes in
+	// it are read from the same project and ref.
+	includeFile struct {
+		kind    string
+		project string
+		ref     string
+		path    string
+	}
+	// inclusion is the state of one Resolve.
+	inclusion struct {
+		*IncludeResolver
+		ctx   context.Context
+		seen  map[string]bool
+		count int
+	}
+)
+
+// NewIncludeResolver reads local and project includes from source, local
+// includes from project at ref. Templates are read from source too, from
+// TemplateProject, see SetTemplates.
+func NewIncludeResolver(source getfile.Source, project, ref string) *IncludeResolver {
+	return &IncludeResolver{
+		source:          source,
+		project:         project,
+		ref:             ref,
+		templates:       source,
+		templateProject: TemplateProject,
+		templateRef:     TemplateRef,
+		client:          &http.Client{Timeout: RemoteTimeout},
+		variables:       map[string]string{},
+	}
+}
+
+// SetTemplates reads include:template files from TemplateDir in project at
+// ref of source, for instances that don't reach gitlab.com.
+func (this *IncludeResolver) SetTemplates(source getfile.Source, project, ref string) *IncludeResolver {
+	this.templates = source
+	this.templateProject = project
+	this.templateRef = ref
+	return this
+}
+
+// SetHTTPClient sets the client include:remote files are downloaded with.
+// Every download is still limited to RemoteTimeout.
+func (this *IncludeResolver) SetHTTPClient(client *http.Client) *IncludeResolver {
+	this.client = client
+	return this
+}
+
+// SetVariables sets the CI variables include:rules are evaluated with.
+func (this *IncludeResolver) SetVariables(vars map[string]string) *IncludeResolver {
+	this.variables = vars
+	return this
+}
+
+// ResolveFile reads path from the project and resolves it, see Resolve.
+func (this *IncludeResolver) ResolveFile(path string) (*Pipeline, error) {
+	return this.ResolveFileContext(context.Background(), path)
+}
+
+// ResolveFileContext is ResolveFile with ctx for downloading remote includes.
+func (this *IncludeResolver) ResolveFileContext(ctx context.Context, path string) (*Pipeline, error) {
+	data, err := this.source.File(this.project, path, this.ref)
+	if err != nil {
+		return nil, err
+	}
+	return this.ResolveContext(ctx, data)
+}
+
+// Resolve parses a pipeline with its includes merged in. Every included file
+// is merged in order, each over the ones before, and the pipeline's own keys
+// last, over all of them. Mappings are merged key by key at every level, any
+// other value replaces the one before. Included files are resolved the same
+// way first, a file included more than once is only merged the first time.
+// include:rules are evaluated with if only, exists and changes always match.
+func (this *IncludeResolver) Resolve(data []byte) (*Pipeline, error) {
+	return this.ResolveContext(context.Background(), data)
+}
+
+// ResolveContext is Resolve with ctx for downloading remote includes, a
+// canceled ctx stops the download.
+func (this *IncludeResolver) ResolveContext(ctx context.Context, data []byte) (*Pipeline, error) {
+	state := &inclusion{IncludeResolver: this, ctx: ctx, seen: map[string]bool{}}
+
+	root := &includeFile{kind: "local", project: this.project, ref: this.ref, path: ParentFile}
+	node, err := state.load(root, data)
+	if err != nil {
+		return nil, err
+	}
+	node, err = state.expand(node, root, 0)
+	if err != nil {
+		return nil, err
+	}
+	return parseRoot(node)
+}
+
+// load parses data into its top level mapping.
+func (this *inclusion) load(file *includeFile, data []byte) (*yaml.Node, error) {
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}, nil
+	}
+
+	node, err := resolve(doc.Content[0])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: expected a mapping at the top level, got %s", file, kindName(node))
+	}
+	return node, nil
+}
+
+// expand merges the files node includes under node.
+func (this *inclusion) expand(node *yaml.Node, at *includeFile, depth int) (*yaml.Node, error) {
+	includes := getKey(node, "include")
+	if includes == nil {
+		return node, nil
+	}
+	setKey(node, "include", nil)
+	if depth >= MaxIncludeDepth {
+		return nil, fmt.Errorf("%s: %w, nested deeper than %d", at, ErrTooManyIncludes, MaxIncludeDepth)
+	}
+
+	merged := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, item := range toList(includes).Content {
+		files, err := this.files(item, at)
+		if err != nil {
+			return nil, fmt.Errorf("%s: include: %w", at, err)
+		}
+
+		for _, file := range files {
+			if this.seen[file.String()] {
+				log.Debug("Skipping ", file, ", it is already included")
+				continue
+			}
+			this.seen[file.String()] = true
+			if this.count++; this.count > MaxIncludes {
+				return nil, fmt.Errorf("%s: %w, more than %d", at, ErrTooManyIncludes, MaxIncludes)
+			}
+
+			log.Debug("Including ", file, " from ", at)
+			data, err := this.read(file)
+			if err != nil {
+				return nil, fmt.Errorf("%s: include: %w", at, err)
+			}
+			child, err := this.load(file, data)
+			if err != nil {
+				return nil, err
+			}
+			child, err = this.expand(child, file, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			merged = deepMerge(merged, child)
+		}
+	}
+
+	return deepMerge(merged, node), nil
+}
+
+// files turns one include entry into the files it stands for, none when its
+// rules leave it out.
+func (this *inclusion) files(item *yaml.Node, at *includeFile) ([]*includeFile, error) {
+	if item.Kind == yaml.ScalarNode {
+		key := "local"
+		if strings.HasPrefix(item.Value, "http://") || strings.HasPrefix(item.Value, "https://") {
+			key = "remote"
+		}
+		item = mapping(key, item)
+	}
+	if item.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("expected a string or mapping, got %s", kindName(item))
+	}
+
+	if rules := getKey(item, "rules"); rules != nil {
+		ok, err := this.included(rules)
+		if err != nil || !ok {
+			return nil, err
+		}
+	}
+	if getKey(item, "inputs") != nil {
+		log.Warn("include inputs are not supported, they are ignored")
+	}
+
+	// Local includes in a file from another project are read from it.
+	project, ref := at.project, at.ref
+	if at.kind != "local" && at.kind != "project" {
+		project, ref = this.project, this.ref
+	}
+
+	switch {
+	case getKey(item, "local") != nil:
+		name := strings.TrimPrefix(getKey(item, "local").Value, "/")
+		if !glob.HasMeta(name) {
+			return []*includeFile{{kind: "local", project: project, ref: ref, path: name}}, nil
+		}
+		return this.glob(project, ref, name)
+	case getKey(item, "project") != nil:
+		project := getKey(item, "project").Value
+		ref := ""
+		if node := getKey(item, "ref"); node != nil {
+			ref = node.Value
+		}
+		files := []*includeFile{}
+		for _, file := range toList(getKey(item, "file")).Content {
+			files = append(files, &includeFile{kind: "project", project: project, ref: ref, path: strings.TrimPrefix(file.Value, "/")})
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("project %s without file", project)
+		}
+		return files, nil
+	case getKey(item, "remote") != nil:
+		return []*includeFile{{kind: "remote", path: getKey(item, "remote").Value}}, nil
+	case getKey(item, "template") != nil:
+		return []*includeFile{{kind: "template", path: getKey(item, "template").Value}}, nil
+	case getKey(item, "component") != nil:
+		return nil, fmt.Errorf("%w: component %s", ErrUnsupported, getKey(item, "component").Value)
+	}
+	return nil, fmt.Errorf("%w: none of local, project, remote or template", ErrUnsupported)
+}
+
+// glob lists the local files matching a wildcard, sorted like GitLab does.
+func (this *inclusion) glob(project, ref, pattern string) ([]*includeFile, error) {
+	re, err := glob.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := this.source.Tree(project, "", ref, true)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []*includeFile{}
+	for _, entry := range entries {
+		ext := path.Ext(entry.Path)
+		if entry.Type == "blob" && (ext == ".yml" || ext == ".yaml") && re.MatchString(entry.Path) {
+			files = append(files, &includeFile{kind: "local", project: project, ref: ref, path: entry.Path})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	return files, nil
+}
+
+// included evaluates include:rules, the first rule whose if holds decides.
+func (this *inclusion) included(node *yaml.Node) (bool, error) {
+	rules := []*JobRule{}
+	if err := normalizeRules(node, "include").Decode(&rules); err != nil {
+		return false, err
+	}
+
+	for _, rule := range rules {
+		if rule.If != nil {
+			ok, err := Evaluate(*rule.If, this.variables)
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		return rule.When == nil || *rule.When != "never", nil
+	}
+	return false, nil
+}
+
+func (this *inclusion) read(file *includeFile) ([]byte, error) {
+	switch file.kind {
+	case "remote":
+		ctx, cancel := context.WithTimeout(this.ctx, RemoteTimeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, file.path, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := this.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: %s", file.path, resp.Status)
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, MaxRemoteSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > MaxRemoteSize {
+			return nil, fmt.Errorf("%s: %w, over %d bytes", file.path, ErrRemoteTooLarge, MaxRemoteSize)
+		}
+		return data, nil
+	case "template":
+		return this.templates.File(this.templateProject, path.Join(TemplateDir, file.path), this.templateRef)
+	default:
+		return this.source.File(file.project, file.path, file.ref)
+	}
+}
+
+func (this *includeFile) String() string {
+	switch this.kind {
+	case "remote":
+		return this.path
+	case "template":
+		return "template " + this.path
+	}
+	if this.project == "" {
+		return this.path
+	}
+	if this.ref == "" {
+		return this.project + "/" + this.path
+	}
+	return this.project + ":" + this.ref + "/" + this.path
+}
+
+// deepMerge merges over into base the way GitLab merges includes: mappings
+// key by key at every level, anything else is replaced. Neither is changed.
+func deepMerge(base, over *yaml.Node) *yaml.Node {
+	if base == nil || base.Kind != yaml.MappingNode || over.Kind != yaml.MappingNode {
+		return over
+	}
+
+	out := *base
+	out.Content = append([]*yaml.Node{}, base.Content...)
+	for i := 0; i < len(over.Content); i += 2 {
+		key, value := over.Content[i], over.Content[i+1]
+		setKey(&out, key.Value, deepMerge(getKey(&out, key.Value), value))
+	}
+	return &out
+}
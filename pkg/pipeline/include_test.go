@@ -0,0 +1,146 @@
+package pipeline
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/reflexias/gitlab-tools/pkg/getfile"
+)
+
+func TestIncludeResolver(t *testing.T) {
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/remote.yml":
+			w.Write([]byte("variables:\n  REMOTE: \"yes\"\n"))
+		case "/slow.yml":
+			<-r.Context().Done()
+		case "/large.yml":
+			w.Write([]byte("# " + strings.Repeat("x", MaxRemoteSize) + "\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer remote.Close()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"ci/base.yml":   "variables:\n  A: base\n  B: base\n.template:\n  script: [base]\n",
+		"ci/nested.yml": "include: ci/base.yml\nvariables:\n  B: nested\n",
+		"ci/jobs/a.yml": "a:\n  stage: test\n  script: [a]\n",
+		"ci/jobs/b.yml": "b:\n  stage: test\n  script: [b]\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		pipeline  string
+		variables map[string]string
+		jobs      []string
+		wantErr   string
+	}{
+		{
+			name:      "local",
+			pipeline:  "include: ci/base.yml\nvariables:\n  A: own\n",
+			variables: map[string]string{"A": "own", "B": "base"},
+		},
+		{
+			name:      "nested",
+			pipeline:  "include:\n  - local: /ci/nested.yml\n",
+			variables: map[string]string{"A": "base", "B": "nested"},
+		},
+		{
+			name:     "glob",
+			pipeline: "include: ci/jobs/*.yml\n",
+			jobs:     []string{"a", "b"},
+		},
+		{
+			name:      "rules",
+			pipeline:  "include:\n  - local: ci/base.yml\n    rules:\n      - if: $CI_COMMIT_BRANCH == \"main\"\n",
+			variables: map[string]string{},
+		},
+		{
+			name:      "remote",
+			pipeline:  "include:\n  - remote: " + remote.URL + "/remote.yml\n",
+			variables: map[string]string{"REMOTE": "yes"},
+		},
+		{
+			name:     "remote missing",
+			pipeline: "include: " + remote.URL + "/missing.yml\n",
+			wantErr:  "404",
+		},
+		{
+			name:     "remote timeout",
+			pipeline: "include: " + remote.URL + "/slow.yml\n",
+			wantErr:  "deadline exceeded",
+		},
+		{
+			name:     "remote too large",
+			pipeline: "include: " + remote.URL + "/large.yml\n",
+			wantErr:  ErrRemoteTooLarge.Error(),
+		},
+		{
+			name:     "component",
+			pipeline: "include:\n  - component: gitlab.com/group/component@1.0\n",
+			wantErr:  ErrUnsupported.Error(),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+
+			resolver := NewIncludeResolver(getfile.NewLocal(dir), "group/project", "main").
+				SetVariables(map[string]string{"CI_COMMIT_BRANCH": "feature"})
+			p, err := resolver.ResolveContext(ctx, []byte(test.pipeline))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("err = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.variables != nil {
+				got := map[string]string{}
+				for name, variable := range p.Variables {
+					got[name] = variable.Value
+				}
+				if len(got) != len(test.variables) {
+					t.Errorf("variables %v, want %v", got, test.variables)
+				}
+				for name, want := range test.variables {
+					if got[name] != want {
+						t.Errorf("variable %s = %q, want %q", name, got[name], want)
+					}
+				}
+			}
+
+			jobs := []string{}
+			for _, stage := range p.Stages {
+				for _, job := range stage.Jobs {
+					jobs = append(jobs, job.Name)
+				}
+			}
+			if test.jobs != nil && !equalStrings(jobs, test.jobs) {
+				t.Errorf("jobs %v, want %v", jobs, test.jobs)
+			}
+		})
+	}
+}
@@ -1,10 +1,14 @@
 package pipeline
 
-import "fmt"
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
 
 type (
 	Job struct {
-		Stage        string
+		Stage        string `yaml:",omitempty"`
 		stage        *Stage
 		Name         string              `yaml:"-"`
 		Image        *JobImage           `yaml:",omitempty"`
@@ -13,26 +17,51 @@ type (
 		IDTokens     map[string]*IDToken `yaml:"id_tokens,omitempty"`
 		Dependencies []string            `yaml:",omitempty"`
 		Needs        []string            `yaml:",omitempty"`
-		Extends      []string            `yaml:",omitempty"`
-		Script       []string            `yaml:",omitempty"`
-		Artifacts    *Artifacts          `yaml:",omitempty"`
-		PullPolicy   *string             `json:"pull_policy,omitempty" yaml:"pull_policy,omitempty"`
-		When         string              `yaml:",omitempty"`
-		Trigger      JobTrigger          `yaml:",omitempty"`
-		Inherit      JobInherit          `yaml:",omitempty"`
-		Cache        []*JobCache         `yaml:",omitempty"`
-		Environment  Environment         `yaml:",omitempty"`
-		Rules        []*JobRule          `yaml:",omitempty"`
-		BeforeScript []string            `yaml:"before_script,omitempty"`
-		AfterScript  []string            `yaml:"after_script,omitempty"`
-		AllowFailure bool                `yaml:"allow_failure,omitempty"`
-		Retry        int                 `yaml:"retry,omitempty"`
-		Services     []*Service          `yaml:"services,omitempty"`
-		Tags         []string            `yaml:"tags,omitempty"`
-		Timeout      string              `yaml:"timeout,omitempty"`
-	}
-	Artifacts struct {
-		Paths []string `yaml:",omitempty"`
+		// Needs that take more than a job name: optional, artifacts, other
+		// projects or pipelines and parallel:matrix. They render after Needs.
+		DetailedNeeds []*JobNeed  `yaml:"-"`
+		Extends       []string    `yaml:",omitempty"`
+		Script        []string    `yaml:",omitempty"`
+		Artifacts     *Artifacts  `yaml:",omitempty"`
+		PullPolicy    *string     `json:"pull_policy,omitempty" yaml:"pull_policy,omitempty"`
+		When          string      `yaml:",omitempty"`
+		Trigger       JobTrigger  `yaml:",omitempty"`
+		Inherit       JobInherit  `yaml:",omitempty"`
+		Cache         []*JobCache `yaml:",omitempty"`
+		Environment   Environment `yaml:",omitempty"`
+		Rules         []*JobRule  `yaml:",omitempty"`
+		BeforeScript  []string    `yaml:"before_script,omitempty"`
+		AfterScript   []string    `yaml:"after_script,omitempty"`
+		AllowFailure  bool        `yaml:"allow_failure,omitempty"`
+		Retry         int         `yaml:"retry,omitempty"`
+		Services      []*Service  `yaml:"services,omitempty"`
+		Tags          []string    `yaml:"tags,omitempty"`
+		Timeout       string      `yaml:"timeout,omitempty"`
+		Interruptible *bool       `yaml:"interruptible,omitempty"`
+		Coverage      string      `yaml:"coverage,omitempty"`
+		ResourceGroup string      `yaml:"resource_group,omitempty"`
+		StartIn       string      `yaml:"start_in,omitempty"`
+		Parallel      *Parallel   `yaml:"parallel,omitempty"`
+		Release       *Release    `yaml:"release,omitempty"`
+		// YAML anchor of a template other jobs merge, and the templates
+		// this job merges.
+		anchor string
+		merges []*Job
+		// Keys written with an empty value, such as needs: [] or
+		// allow_failure: false, which omitempty would leave out but which
+		// mean something to GitLab and override a job this one extends.
+		zeros map[string]bool
+		// !reference lines of the scripts, which are only commands.
+		references scriptReferences
+	}
+	JobNeed struct {
+		Job       string    `yaml:"job,omitempty"`
+		Project   string    `yaml:"project,omitempty"`
+		Ref       string    `yaml:"ref,omitempty"`
+		Pipeline  string    `yaml:"pipeline,omitempty"`
+		Artifacts *bool     `yaml:"artifacts,omitempty"`
+		Optional  bool      `yaml:"optional,omitempty"`
+		Parallel  *Parallel `yaml:"parallel,omitempty"`
 	}
 	JobRule struct {
 		Exists       []string          `yaml:",omitempty"`
@@ -41,10 +70,15 @@ type (
 		Variables    map[string]string `yaml:"variables,omitempty"`
 		If           *string           `yaml:",omitempty"`
 		AllowFailure *bool             `yaml:"allow_failure,omitempty"`
+		// Reference makes the rule a !reference to other rules.
+		Reference Reference `yaml:"-"`
 	}
 	JobImage struct {
 		Name       string `yaml:",omitempty"`
 		Entrypoint string `yaml:",omitempty"`
+		// EntrypointArgs is the entrypoint as a list, such as [""] to clear
+		// the one of the image. It renders instead of Entrypoint when set.
+		EntrypointArgs []string `yaml:"-"`
 	}
 	JobTrigger struct {
 		Strategy string              `yaml:",omitempty"`
@@ -55,14 +89,20 @@ type (
 	JobTriggerInclude struct {
 		Artifact string `yaml:",omitempty"`
 		Job      string `yaml:",omitempty"`
+		Local    string `yaml:",omitempty"`
+		Project  string `yaml:",omitempty"`
+		Ref      string `yaml:",omitempty"`
+		File     string `yaml:",omitempty"`
 		Remote   string `yaml:",omitempty"`
+		Template string `yaml:",omitempty"`
 	}
 	JobInherit struct {
 		Variables bool `yaml:",omitempty"`
-	}
-	JobCache struct {
-		Key   string   `yaml:",omitempty"`
-		Paths []string `yaml:",omitempty"`
+		// VariableNames and DefaultKeys limit what is inherited to the
+		// names listed, nothing when empty but not nil. VariableNames wins
+		// over Variables.
+		VariableNames []string `yaml:"-"`
+		DefaultKeys   []string `yaml:"-"`
 	}
 	Secret struct {
 		Vault VaultSecret `yaml:",omitempty"`
@@ -79,12 +119,6 @@ type (
 	IDToken struct {
 		Aud []string `yaml:",omitempty"`
 	}
-	Environment struct {
-		Name   string `yaml:",omitempty"`
-		Url    string `yaml:",omitempty"`
-		Action string `yaml:",omitempty"`
-		Tier   string `yaml:"deployment_tier,omitempty"`
-	}
 )
 
 func NewJob(format string, a ...any) *Job {
@@ -130,6 +164,14 @@ func (this *Job) NeedsJob(j *Job) {
 	this.Needs = append(this.Needs, j.Name)
 }
 
+// NeedsMatrix needs only the instances of a parallel:matrix job matching m.
+func (this *Job) NeedsMatrix(j *Job, m ...*Matrix) {
+	this.DetailedNeeds = append(this.DetailedNeeds, &JobNeed{
+		Job:      j.Name,
+		Parallel: &Parallel{Matrix: m},
+	})
+}
+
 func (this *Job) Dependency(format string, a ...any) {
 	name := fmt.Sprintf(format, a...)
 	this.Dependencies = append(this.Dependencies, name)
@@ -146,6 +188,14 @@ func (this *Job) AddCommand(command string) {
 	this.Script = append(this.Script, command)
 }
 
+func (this *Job) AddBeforeCommand(command string) {
+	this.BeforeScript = append(this.BeforeScript, command)
+}
+
+func (this *Job) AddAfterCommand(command string) {
+	this.AfterScript = append(this.AfterScript, command)
+}
+
 // Add a Vault Secret CICD Variable, engine, engine-path, secret path, field
 // https://docs.gitlab.com/ee/ci/yaml/#secretsvault
 func (this *Job) AddVaultSecret(Variable, engine, enginePath, secretPath, field string) {
@@ -174,13 +224,17 @@ func (this *Job) SetWhen(when string) {
 	this.When = when
 }
 
-func (this *Job) SetEnvironment(name, action, url, tier string) {
-	this.Environment = Environment{
-		Name:   name,
-		Action: action,
-		Url:    url,
-		Tier:   tier,
-	}
+// SetAllowFailure sets allow_failure, false too, so it overrides a job this
+// one extends.
+func (this *Job) SetAllowFailure(allowFailure bool) {
+	this.AllowFailure = allowFailure
+	this.setZero("allow_failure", true)
+}
+
+// SetRetry sets retry, 0 too, so it overrides a job this one extends.
+func (this *Job) SetRetry(count int) {
+	this.Retry = count
+	this.setZero("retry", true)
 }
 
 // BuildJob.AddRule("if ...", "always", false) // if, when, allow failure
@@ -225,15 +279,32 @@ func (this *Job) AddChangesWhenRule(changes []string, when string) {
 	})
 }
 
-func (this *Job) AddArtifact(format string, a ...any) {
-	file := fmt.Sprintf(format, a...)
-	if this.Artifacts == nil {
-		this.Artifacts = &Artifacts{
-			Paths: []string{},
+// copy returns a rule that shares nothing with this one.
+func (this *JobRule) copy() *JobRule {
+	rule := &JobRule{
+		Exists:    append([]string(nil), this.Exists...),
+		Changes:   append([]string(nil), this.Changes...),
+		Reference: append(Reference(nil), this.Reference...),
+	}
+	if this.When != nil {
+		when := *this.When
+		rule.When = &when
+	}
+	if this.If != nil {
+		condition := *this.If
+		rule.If = &condition
+	}
+	if this.AllowFailure != nil {
+		allowFailure := *this.AllowFailure
+		rule.AllowFailure = &allowFailure
+	}
+	if this.Variables != nil {
+		rule.Variables = map[string]string{}
+		for name, value := range this.Variables {
+			rule.Variables[name] = value
 		}
 	}
-
-	this.Artifacts.Paths = append(this.Artifacts.Paths, file)
+	return rule
 }
 
 func (this *Job) AddCache(key string, paths ...string) {
@@ -244,3 +315,268 @@ func (this *Job) AddCache(key string, paths ...string) {
 		})
 	}
 }
+
+// NoNeeds renders needs: [], so the job starts without waiting for earlier
+// stages.
+func (this *Job) NoNeeds() {
+	this.Needs = []string{}
+	this.DetailedNeeds = nil
+	this.setZero("needs", true)
+}
+
+// NoDependencies renders dependencies: [], so the job downloads no artifacts.
+func (this *Job) NoDependencies() {
+	this.Dependencies = []string{}
+	this.setZero("dependencies", true)
+}
+
+func (this *Job) setZero(key string, zero bool) {
+	if this.zeros == nil {
+		this.zeros = map[string]bool{}
+	}
+	this.zeros[key] = zero
+}
+
+func (this *Job) scripts() map[string]*[]string {
+	return map[string]*[]string{
+		"before_script": &this.BeforeScript,
+		"script":        &this.Script,
+		"after_script":  &this.AfterScript,
+	}
+}
+
+// allowFailureSet reports whether allow_failure was written, false included.
+func (this *Job) allowFailureSet() bool {
+	return this.AllowFailure || this.zeros["allow_failure"]
+}
+
+// allNeeds is Needs and DetailedNeeds together.
+func (this *Job) allNeeds() []*JobNeed {
+	needs := []*JobNeed{}
+	for _, name := range this.Needs {
+		needs = append(needs, &JobNeed{Job: name})
+	}
+	return append(needs, this.DetailedNeeds...)
+}
+
+// zeroKeys are the keys MarshalYAML writes with an empty value when they
+// were set that way on purpose, in the order they render.
+var zeroKeys = []struct {
+	key   string
+	empty func(job *Job) bool
+	value func() *yaml.Node
+}{
+	{"dependencies", func(job *Job) bool { return len(job.Dependencies) == 0 }, func() *yaml.Node { return sequence() }},
+	{"needs", func(job *Job) bool { return len(job.Needs) == 0 && len(job.DetailedNeeds) == 0 }, func() *yaml.Node { return sequence() }},
+	{"allow_failure", func(job *Job) bool { return !job.AllowFailure }, func() *yaml.Node { return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "false"} }},
+	{"retry", func(job *Job) bool { return job.Retry == 0 }, func() *yaml.Node { return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: "0"} }},
+}
+
+// MarshalYAML adds DetailedNeeds to needs, puts the references in the
+// scripts and renders keys set to an empty value on purpose, which omitempty
+// leaves out.
+func (this *Job) MarshalYAML() (any, error) {
+	type job Job
+	placeholder := *this
+	this.references.placeholders(placeholder.scripts())
+	node, err := encodeNode((*job)(&placeholder))
+	if err != nil {
+		return nil, err
+	}
+	this.references.marshal(node, this.scripts())
+
+	if len(this.DetailedNeeds) > 0 {
+		needs := getKey(node, "needs")
+		if needs == nil {
+			needs = sequence()
+			setKey(node, "needs", needs)
+		}
+		for _, need := range this.DetailedNeeds {
+			value, err := encodeNode(need)
+			if err != nil {
+				return nil, fmt.Errorf("needs: %w", err)
+			}
+			needs.Content = append(needs.Content, value)
+		}
+	}
+
+	for _, zero := range zeroKeys {
+		if this.zeros[zero.key] && zero.empty(this) {
+			setKey(node, zero.key, zero.value())
+		}
+	}
+	return node, nil
+}
+
+// UnmarshalYAML splits needs into Needs and DetailedNeeds, takes the
+// references out of the scripts and remembers the keys written with an empty
+// value.
+func (this *Job) UnmarshalYAML(node *yaml.Node) error {
+	type job Job
+	if node.Kind != yaml.MappingNode {
+		return node.Decode((*job)(this))
+	}
+
+	rest := *node
+	rest.Content = []*yaml.Node{}
+	var needs *yaml.Node
+	scripts := this.scripts()
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == "needs" {
+			needs = node.Content[i+1]
+			continue
+		}
+		if _, ok := scripts[node.Content[i].Value]; ok {
+			continue
+		}
+		rest.Content = append(rest.Content, node.Content[i], node.Content[i+1])
+	}
+	if err := rest.Decode((*job)(this)); err != nil {
+		return err
+	}
+	if err := this.references.unmarshal(node, scripts); err != nil {
+		return err
+	}
+
+	if needs = toList(needs); needs != nil && needs.Kind == yaml.SequenceNode {
+		this.Needs = []string{}
+		for _, item := range needs.Content {
+			if item.Kind == yaml.ScalarNode {
+				this.Needs = append(this.Needs, item.Value)
+				continue
+			}
+			need := &JobNeed{}
+			if err := item.Decode(need); err != nil {
+				return err
+			}
+			this.DetailedNeeds = append(this.DetailedNeeds, need)
+		}
+	}
+
+	for _, zero := range zeroKeys {
+		if value := getKey(node, zero.key); value != nil && zero.empty(this) && value.ShortTag() != "!!null" {
+			this.setZero(zero.key, true)
+		}
+	}
+	return nil
+}
+
+// Entrypoint renders as a string like it always has, EntrypointArgs as a
+// list.
+func (this *JobImage) MarshalYAML() (any, error) {
+	type image JobImage
+	if this.EntrypointArgs == nil {
+		return (*image)(this), nil
+	}
+	return struct {
+		Name       string   `yaml:",omitempty"`
+		Entrypoint []string `yaml:"entrypoint"`
+	}{this.Name, this.EntrypointArgs}, nil
+}
+
+func (this *JobImage) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		this.Name = node.Value
+		return nil
+	}
+	image := struct {
+		Name       string
+		Entrypoint yaml.Node
+	}{}
+	if err := node.Decode(&image); err != nil {
+		return err
+	}
+	this.Name = image.Name
+	if image.Entrypoint.Kind == 0 {
+		return nil
+	}
+	if image.Entrypoint.Kind == yaml.ScalarNode {
+		this.Entrypoint = image.Entrypoint.Value
+		return nil
+	}
+	this.EntrypointArgs = []string{}
+	return image.Entrypoint.Decode(&this.EntrypointArgs)
+}
+
+// A list renders as the list, an empty one as false, and Variables as true.
+func (this JobInherit) MarshalYAML() (any, error) {
+	out := struct {
+		Default   any `yaml:",omitempty"`
+		Variables any `yaml:",omitempty"`
+	}{}
+	if this.DefaultKeys != nil {
+		out.Default = inheritValue(this.DefaultKeys)
+	}
+	if this.VariableNames != nil {
+		out.Variables = inheritValue(this.VariableNames)
+	} else if this.Variables {
+		out.Variables = true
+	}
+	return out, nil
+}
+
+func (this *JobInherit) UnmarshalYAML(node *yaml.Node) error {
+	inherit := struct {
+		Default   yaml.Node
+		Variables yaml.Node
+	}{}
+	if err := node.Decode(&inherit); err != nil {
+		return err
+	}
+
+	var err error
+	if this.DefaultKeys, _, err = inheritNames(&inherit.Default); err != nil {
+		return err
+	}
+	this.VariableNames, this.Variables, err = inheritNames(&inherit.Variables)
+	return err
+}
+
+func (this JobInherit) IsZero() bool {
+	return !this.Variables && this.VariableNames == nil && this.DefaultKeys == nil
+}
+
+func inheritValue(names []string) any {
+	if len(names) == 0 {
+		return false
+	}
+	return names
+}
+
+// inheritNames reads true, false or a list of names: true inherits
+// everything, which is what an omitted key does too.
+func inheritNames(node *yaml.Node) (names []string, all bool, err error) {
+	if node.Kind == 0 {
+		return nil, false, nil
+	}
+	if node.Kind == yaml.ScalarNode {
+		inherit := true
+		if err := node.Decode(&inherit); err != nil {
+			return nil, false, err
+		}
+		if inherit {
+			return nil, true, nil
+		}
+		return []string{}, false, nil
+	}
+	names = []string{}
+	return names, false, toList(node).Decode(&names)
+}
+
+// A need that only names a job in the same pipeline renders as a plain string.
+func (this *JobNeed) MarshalYAML() (any, error) {
+	if this.Project == "" && this.Ref == "" && this.Pipeline == "" && this.Artifacts == nil && !this.Optional && this.Parallel == nil {
+		return this.Job, nil
+	}
+	type need JobNeed
+	return (*need)(this), nil
+}
+
+func (this *JobNeed) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		this.Job = node.Value
+		return nil
+	}
+	type need JobNeed
+	return node.Decode((*need)(this))
+}
@@ -0,0 +1,187 @@
+package pipeline
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// GitLab refuses parallel counts and matrices above this many jobs.
+const MaxParallel = 200
+
+type (
+	// Parallel is either a plain job count or a matrix, Count is ignored when
+	// Matrix is set.
+	Parallel struct {
+		Count  int
+		Matrix []*Matrix
+	}
+	// Matrix is one entry of parallel:matrix. Variables keep the order they
+	// were added in because GitLab names the jobs after the values in that
+	// order.
+	Matrix struct {
+		Variables []*MatrixVariable
+	}
+	MatrixVariable struct {
+		Name   string
+		Values []string
+	}
+	// ParallelJob is one concrete job GitLab creates from a parallel job.
+	ParallelJob struct {
+		Name      string
+		Variables map[string]string
+	}
+)
+
+func NewMatrix() *Matrix {
+	return &Matrix{
+		Variables: []*MatrixVariable{},
+	}
+}
+
+// Matrix.Add("PROVIDER", "aws", "gcp").Add("STACK", "app")
+func (this *Matrix) Add(name string, values ...string) *Matrix {
+	this.Variables = append(this.Variables, &MatrixVariable{
+		Name:   name,
+		Values: values,
+	})
+	return this
+}
+
+func (this *Job) SetParallel(count int) {
+	this.Parallel = &Parallel{Count: count}
+}
+
+// BuildJob.AddMatrix().Add("GOOS", "linux", "darwin").Add("GOARCH", "amd64")
+func (this *Job) AddMatrix() *Matrix {
+	if this.Parallel == nil {
+		this.Parallel = &Parallel{}
+	}
+	matrix := NewMatrix()
+	this.Parallel.Matrix = append(this.Parallel.Matrix, matrix)
+
+	return matrix
+}
+
+// ExpandParallel returns the jobs GitLab creates for this job, named the same
+// way GitLab names them: "build 1/3" for a count, "build 1/1" too, and
+// "build: [linux, amd64]" for a matrix. A job without parallel, or with a
+// count below one that Validate rejects, expands to itself.
+func (this *Job) ExpandParallel() []*ParallelJob {
+	return this.Parallel.Expand(this.Name)
+}
+
+func (this *Parallel) Expand(name string) []*ParallelJob {
+	if this.IsZero() {
+		return []*ParallelJob{{Name: name, Variables: map[string]string{}}}
+	}
+
+	jobs := []*ParallelJob{}
+	if len(this.Matrix) == 0 {
+		for i := 1; i <= this.Count; i++ {
+			jobs = append(jobs, &ParallelJob{
+				Name:      fmt.Sprintf("%s %d/%d", name, i, this.Count),
+				Variables: map[string]string{},
+			})
+		}
+		return jobs
+	}
+
+	for _, matrix := range this.Matrix {
+		for _, values := range matrix.combinations() {
+			job := &ParallelJob{
+				Name:      name + ": [" + strings.Join(values, ", ") + "]",
+				Variables: map[string]string{},
+			}
+			for i, variable := range matrix.Variables {
+				job.Variables[variable.Name] = values[i]
+			}
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+// combinations is the cartesian product of the variable values, the first
+// variable changing slowest.
+func (this *Matrix) combinations() [][]string {
+	out := [][]string{{}}
+	for _, variable := range this.Variables {
+		next := [][]string{}
+		for _, prefix := range out {
+			for _, value := range variable.Values {
+				next = append(next, append(append([]string{}, prefix...), value))
+			}
+		}
+		out = next
+	}
+	return out
+}
+
+// A count below one without a matrix creates no parallel jobs, GitLab
+// rejects parallel: 0 so it is left out. Validate reports it.
+func (this *Parallel) IsZero() bool {
+	return this == nil || (this.Count < 1 && len(this.Matrix) == 0)
+}
+
+func (this *Parallel) MarshalYAML() (any, error) {
+	if len(this.Matrix) == 0 {
+		return this.Count, nil
+	}
+	return map[string]any{"matrix": this.Matrix}, nil
+}
+
+func (this *Parallel) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		count, err := strconv.Atoi(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: parallel must be a number or a matrix", node.Line)
+		}
+		this.Count = count
+		return nil
+	}
+
+	parallel := struct {
+		Matrix []*Matrix
+	}{}
+	if err := node.Decode(&parallel); err != nil {
+		return err
+	}
+	this.Matrix = parallel.Matrix
+
+	return nil
+}
+
+// A variable with a single value renders as a plain string.
+func (this *Matrix) MarshalYAML() (any, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, variable := range this.Variables {
+		value := &yaml.Node{}
+		if len(variable.Values) == 1 {
+			value = scalar(variable.Values[0])
+		} else if err := value.Encode(variable.Values); err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, scalar(variable.Name), value)
+	}
+	return node, nil
+}
+
+func (this *Matrix) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: matrix entries must be mappings", node.Line)
+	}
+
+	this.Variables = []*MatrixVariable{}
+	for i := 0; i < len(node.Content); i += 2 {
+		variable := &MatrixVariable{Name: node.Content[i].Value}
+		if err := toList(node.Content[i+1]).Decode(&variable.Values); err != nil {
+			return err
+		}
+		this.Variables = append(this.Variables, variable)
+	}
+
+	return nil
+}
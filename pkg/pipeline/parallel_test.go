@@ -0,0 +1,226 @@
+package pipeline
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParallelExpand(t *testing.T) {
+	tests := []struct {
+		name     string
+		parallel *Parallel
+		want     []string
+	}{
+		{"nil", nil, []string{"build"}},
+		{"one", &Parallel{Count: 1}, []string{"build 1/1"}},
+		{"zero", &Parallel{}, []string{"build"}},
+		{"count", &Parallel{Count: 3}, []string{"build 1/3", "build 2/3", "build 3/3"}},
+		{
+			name: "matrix",
+			parallel: &Parallel{Matrix: []*Matrix{
+				NewMatrix().Add("GOOS", "linux", "darwin").Add("GOARCH", "amd64", "arm64"),
+			}},
+			want: []string{
+				"build: [linux, amd64]",
+				"build: [linux, arm64]",
+				"build: [darwin, amd64]",
+				"build: [darwin, arm64]",
+			},
+		},
+		{
+			name: "matrices",
+			parallel: &Parallel{Count: 5, Matrix: []*Matrix{
+				NewMatrix().Add("GOOS", "linux"),
+				NewMatrix().Add("GOOS", "windows").Add("SHELL", "pwsh"),
+			}},
+			want: []string{"build: [linux]", "build: [windows, pwsh]"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			names := []string{}
+			for _, job := range test.parallel.Expand("build") {
+				names = append(names, job.Name)
+			}
+			if !equalStrings(names, test.want) {
+				t.Errorf("got %q, want %q", names, test.want)
+			}
+		})
+	}
+}
+
+func TestParallelVariables(t *testing.T) {
+	parallel := &Parallel{Matrix: []*Matrix{NewMatrix().Add("GOOS", "linux").Add("GOARCH", "amd64", "arm64")}}
+	jobs := parallel.Expand("build")
+	if len(jobs) != 2 {
+		t.Fatalf("got %d jobs, want 2", len(jobs))
+	}
+	if got := jobs[1].Variables; got["GOOS"] != "linux" || got["GOARCH"] != "arm64" {
+		t.Errorf("variables = %v", got)
+	}
+}
+
+func TestParallelRender(t *testing.T) {
+	tests := []struct {
+		name     string
+		parallel *Parallel
+		want     string
+	}{
+		{"zero", &Parallel{}, "job: {}\n"},
+		{"negative", &Parallel{Count: -1}, "job: {}\n"},
+		{"count", &Parallel{Count: 2}, "job:\n    parallel: 2\n"},
+		{
+			name:     "matrix",
+			parallel: &Parallel{Matrix: []*Matrix{NewMatrix().Add("GOOS", "linux").Add("GOARCH", "amd64", "arm64")}},
+			want:     "job:\n    parallel:\n        matrix:\n            - GOOS: linux\n              GOARCH:\n                - amd64\n                - arm64\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := MarshalE("job", &Job{Parallel: test.parallel})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != test.want {
+				t.Errorf("got\n%s\nwant\n%s", out, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateParallel(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr []error
+	}{
+		{
+			name: "parallel instances",
+			yaml: `
+build:
+  stage: build
+  parallel:
+    matrix:
+      - GOOS: [linux, darwin]
+  script: [a]
+shards:
+  stage: build
+  parallel: 2
+  script: [a]
+test:
+  stage: test
+  needs:
+    - "build: [linux]"
+    - shards 2/2
+    - job: build
+      parallel:
+        matrix:
+          - GOOS: darwin
+  script: [a]
+`,
+		},
+		{
+			name: "unknown parallel instance",
+			yaml: `
+build:
+  stage: build
+  parallel:
+    matrix:
+      - GOOS: [linux]
+  script: [a]
+test:
+  needs:
+    - job: build
+      parallel:
+        matrix:
+          - GOOS: windows
+  script: [a]
+`,
+			wantErr: []error{ErrUnknownJob},
+		},
+		{
+			name:    "parallel zero",
+			yaml:    "job:\n  parallel: 0\n  script: [a]\n",
+			wantErr: []error{ErrParallel},
+		},
+		{
+			name:    "parallel too many",
+			yaml:    "job:\n  parallel:\n    matrix:\n      - A: [1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15]\n        B: [1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15]\n  script: [a]\n",
+			wantErr: []error{ErrParallel},
+		},
+		{
+			name: "needs parallel one",
+			yaml: `
+build:
+  stage: build
+  parallel: 1
+  script: [a]
+test:
+  needs: ["build 1/1"]
+  script: [a]
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := Parse([]byte(test.yaml))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = p.Validate()
+			errs := Errors{}
+			if err != nil && !errors.As(err, &errs) {
+				t.Fatalf("err = %v, want Errors", err)
+			}
+			if len(errs) != len(test.wantErr) {
+				t.Fatalf("got %d errors, want %d: %v", len(errs), len(test.wantErr), err)
+			}
+			for i, want := range test.wantErr {
+				if !errors.Is(errs[i], want) {
+					t.Errorf("error %d = %v, want %v", i, errs[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateParallelCount(t *testing.T) {
+	p := NewPipeline("test")
+	job := p.Stage("test").Job("job")
+	job.AddCommand("a")
+	job.SetParallel(0)
+
+	if err := p.Validate(); !errors.Is(err, ErrParallel) {
+		t.Errorf("err = %v, want %v", err, ErrParallel)
+	}
+	if out := p.Render(); strings.Contains(out, "parallel:") {
+		t.Errorf("rendered parallel: 0\n%s", out)
+	}
+}
+
+func TestNeedsMatrix(t *testing.T) {
+	p := NewPipeline("test")
+	build := p.Stage("build").Job("build")
+	build.AddCommand("a")
+	build.AddMatrix().Add("GOOS", "linux", "darwin")
+	test := p.Stage("test").Job("test")
+	test.AddCommand("a")
+	test.NeedsMatrix(build, NewMatrix().Add("GOOS", "darwin"))
+
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	out, err := MarshalE("test", test)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "needs:\n        - job: build\n          parallel:\n            matrix:\n                - GOOS: darwin\n"
+	if !strings.Contains(out, want) {
+		t.Errorf("got\n%s\nwant it to contain\n%s", out, want)
+	}
+}
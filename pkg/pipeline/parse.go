@@ -0,0 +1,639 @@
+package pipeline
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// Stages GitLab uses when a file does not declare any.
+	defaultStages = []string{"build", "test", "deploy"}
+	// Keys in the top level mapping that are not jobs.
+	globalKeywords = map[string]bool{
+		"default":       true,
+		"include":       true,
+		"stages":        true,
+		"variables":     true,
+		"workflow":      true,
+		"cache":         true,
+		"image":         true,
+		"services":      true,
+		"before_script": true,
+		"after_script":  true,
+	}
+)
+
+// Parse reads an existing .gitlab-ci.yml into a Pipeline. Stages and jobs keep
+// the order they were written in so the result can be modified and rendered
+// again. Keywords the model can't hold are logged as warnings and dropped.
+func Parse(data []byte) (*Pipeline, error) {
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(data, doc); err != nil {
+		return nil, err
+	}
+
+	if len(doc.Content) == 0 {
+		return NewPipeline(""), nil
+	}
+
+	root, err := resolve(doc.Content[0])
+	if err != nil {
+		return nil, err
+	}
+	return parseRoot(root)
+}
+
+// parseRoot builds a Pipeline from the resolved top level mapping.
+func parseRoot(root *yaml.Node) (*Pipeline, error) {
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("expected a mapping at the top level, got %s", kindName(root))
+	}
+
+	var err error
+	pipeline := NewPipeline("")
+	declared := []string{}
+	jobs := []*Job{}
+
+	for i := 0; i < len(root.Content); i += 2 {
+		key, value := root.Content[i].Value, root.Content[i+1]
+
+		switch {
+		case key == "default":
+			err = parseDefault(value, &pipeline.Default)
+		case key == "workflow":
+			warnUnknown(value, PipelineWorkflow{}, "workflow")
+			setKey(value, "rules", normalizeRules(getKey(value, "rules"), "workflow"))
+			err = value.Decode(&pipeline.Workflow)
+		case key == "include":
+			pipeline.Includes, err = parseIncludes(value)
+		case key == "variables":
+			err = value.Decode(&pipeline.Variables)
+		case key == "stages":
+			err = toList(value).Decode(&declared)
+		case key == "cache":
+			err = normalizeCache(value).Decode(&pipeline.Cache)
+		case globalKeywords[key]:
+			// Global image, services and scripts are the deprecated spelling
+			// of the same keys under default.
+			log.Debugf("moving global %s to default", key)
+			err = parseDefault(mapping(key, value), &pipeline.Default)
+		case strings.HasPrefix(key, "."):
+			// Hidden keys also hold plain anchors, only keep the ones that
+			// look like jobs.
+			if !isTemplate(value) {
+				log.Debug("Skipping hidden key: " + key)
+				break
+			}
+			var template *Job
+			template, err = parseJob(key, value)
+			if err == nil {
+				pipeline.Templates = append(pipeline.Templates, template)
+			}
+		default:
+			var job *Job
+			job, err = parseJob(key, value)
+			if err == nil {
+				jobs = append(jobs, job)
+			}
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+
+	stageJobs := map[string][]*Job{}
+	for _, job := range jobs {
+		if job.Stage == "" {
+			job.Stage = "test"
+		}
+		stageJobs[job.Stage] = append(stageJobs[job.Stage], job)
+	}
+
+	names := declared
+	if len(names) == 0 {
+		for _, name := range defaultStages {
+			if len(stageJobs[name]) > 0 {
+				names = append(names, name)
+			}
+		}
+	}
+	// .pre and .post always exist, even when they are not declared.
+	if len(stageJobs[".pre"]) > 0 && !contains(names, ".pre") {
+		names = append([]string{".pre"}, names...)
+	}
+	if len(stageJobs[".post"]) > 0 && !contains(names, ".post") {
+		names = append(names, ".post")
+	}
+
+	for _, job := range jobs {
+		if !contains(names, job.Stage) {
+			return nil, fmt.Errorf("%s: chosen stage %s does not exist", job.Name, job.Stage)
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		stage := pipeline.Stage("%s", name)
+		for _, job := range stageJobs[name] {
+			job.stage = stage
+			stage.Jobs = append(stage.Jobs, job)
+		}
+	}
+
+	return pipeline, nil
+}
+
+// ParseFile reads and parses the pipeline at path.
+func ParseFile(path string) (*Pipeline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+func parseJob(name string, node *yaml.Node) (*Job, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("expected a mapping, got %s", kindName(node))
+	}
+	warnUnknown(node, Job{}, name)
+	// Variables that are references, decoding into any loses the tag.
+	references := map[string]Reference{}
+
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i].Value, node.Content[i+1]
+		where := name + ":" + key
+
+		switch key {
+		case "services":
+			value = normalizeServices(value)
+		case "script", "before_script", "after_script":
+			value = flattenScript(value)
+		case "extends", "tags", "dependencies":
+			value = toList(value)
+		case "cache":
+			value = normalizeCache(value)
+		case "artifacts":
+			value = normalizeArtifacts(value)
+		case "environment":
+			value = toMapping(value, "name")
+		case "trigger":
+			value = normalizeTrigger(value)
+		case "rules":
+			value = normalizeRules(value, where)
+		case "retry":
+			value = normalizeRetry(value, where)
+		case "secrets":
+			value = normalizeSecrets(value)
+		case "id_tokens":
+			for j := 1; j < len(value.Content); j += 2 {
+				setKey(value.Content[j], "aud", toList(getKey(value.Content[j], "aud")))
+			}
+		case "variables":
+			if value.Tag == "!reference" {
+				log.Warnf("%s: a !reference for all variables is not supported and will be dropped", where)
+				value = nil
+				break
+			}
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				ref := Reference{}
+				if value.Content[j+1].Tag == "!reference" && value.Content[j+1].Decode(&ref) == nil {
+					references[value.Content[j].Value] = ref
+				}
+			}
+		case "allow_failure":
+			if value.Kind == yaml.MappingNode {
+				log.Warnf("%s: exit_codes are not supported and will be dropped", where)
+				value = nil
+			}
+		}
+
+		if value == nil {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			i -= 2
+			continue
+		}
+		node.Content[i+1] = value
+	}
+
+	job := NewJob("%s", name)
+	if err := node.Decode(job); err != nil {
+		return nil, err
+	}
+	job.Name = name
+	for key, ref := range references {
+		job.Variables[key] = ref
+	}
+
+	return job, nil
+}
+
+func parseDefault(node *yaml.Node, def *PipelineDefault) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("expected a mapping, got %s", kindName(node))
+	}
+	warnUnknown(node, PipelineDefault{}, "default")
+
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i].Value, node.Content[i+1]
+
+		switch key {
+		case "image":
+			if value.Kind == yaml.MappingNode {
+				if len(value.Content) > 2 {
+					log.Warn("default:image: only the image name is supported")
+				}
+				value = getKey(value, "name")
+			}
+		case "services":
+			value = normalizeServices(value)
+		case "before_script", "after_script":
+			value = flattenScript(value)
+		case "tags":
+			value = toList(value)
+		case "retry":
+			value = toMapping(value, "max")
+		}
+
+		if value == nil {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			i -= 2
+			continue
+		}
+		node.Content[i+1] = value
+	}
+
+	return node.Decode(def)
+}
+
+// isTemplate reports whether a hidden key is a mapping with at least one job
+// keyword.
+func isTemplate(node *yaml.Node) bool {
+	if node.Kind != yaml.MappingNode {
+		return false
+	}
+	t := reflect.TypeOf(Job{})
+	for i := 0; i < len(node.Content); i += 2 {
+		for j := 0; j < t.NumField(); j++ {
+			if name := yamlName(t.Field(j)); name != "-" && name == node.Content[i].Value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseIncludes accepts every spelling of include: a single string, a mapping
+// or a list of either. A project include with a list of files becomes one
+// entry per file.
+func parseIncludes(node *yaml.Node) ([]PipelineIncludes, error) {
+	includes := []PipelineIncludes{}
+
+	for _, item := range toList(node).Content {
+		if item.Kind == yaml.ScalarNode {
+			key := "local"
+			if strings.HasPrefix(item.Value, "http://") || strings.HasPrefix(item.Value, "https://") {
+				key = "remote"
+			}
+			item = mapping(key, item)
+		}
+		warnUnknown(item, PipelineIncludes{}, "include")
+
+		files := getKey(item, "file")
+		if files == nil || files.Kind != yaml.SequenceNode {
+			include := PipelineIncludes{}
+			if err := item.Decode(&include); err != nil {
+				return nil, err
+			}
+			includes = append(includes, include)
+			continue
+		}
+
+		for _, file := range files.Content {
+			setKey(item, "file", file)
+			include := PipelineIncludes{}
+			if err := item.Decode(&include); err != nil {
+				return nil, err
+			}
+			includes = append(includes, include)
+		}
+	}
+
+	return includes, nil
+}
+
+func normalizeServices(node *yaml.Node) *yaml.Node {
+	node = toList(node)
+	for i, service := range node.Content {
+		service = toMapping(service, "name")
+		setKey(service, "entrypoint", toList(getKey(service, "entrypoint")))
+		setKey(service, "command", toList(getKey(service, "command")))
+		node.Content[i] = service
+	}
+	return node
+}
+
+func normalizeCache(node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.MappingNode {
+		node = sequence(node)
+	}
+	for _, cache := range node.Content {
+		setKey(cache, "paths", toList(getKey(cache, "paths")))
+		setKey(cache, "fallback_keys", toList(getKey(cache, "fallback_keys")))
+	}
+	return node
+}
+
+func normalizeArtifacts(node *yaml.Node) *yaml.Node {
+	setKey(node, "paths", toList(getKey(node, "paths")))
+	setKey(node, "exclude", toList(getKey(node, "exclude")))
+
+	reports := getKey(node, "reports")
+	if reports == nil || reports.Kind != yaml.MappingNode {
+		return node
+	}
+	for i := 0; i < len(reports.Content); i += 2 {
+		if reports.Content[i].Value != "coverage_report" {
+			reports.Content[i+1] = toList(reports.Content[i+1])
+		}
+	}
+	return node
+}
+
+func normalizeTrigger(node *yaml.Node) *yaml.Node {
+	node = toMapping(node, "project")
+
+	include := getKey(node, "include")
+	if include == nil {
+		return node
+	}
+	include = toList(include)
+	for i, item := range include.Content {
+		include.Content[i] = toMapping(item, "local")
+	}
+	setKey(node, "include", include)
+
+	return node
+}
+
+// normalizeRules reduces the mapping forms of changes and exists to their
+// paths, which is all JobRule can hold.
+func normalizeRules(node *yaml.Node, where string) *yaml.Node {
+	if node == nil {
+		return nil
+	}
+	if node.Tag == "!reference" {
+		node = sequence(node)
+	}
+	for _, rule := range node.Content {
+		for _, key := range []string{"changes", "exists"} {
+			value := getKey(rule, key)
+			if value == nil || value.Kind != yaml.MappingNode {
+				continue
+			}
+			if len(value.Content) > 2 {
+				log.Warnf("%s: only the paths of rules:%s are supported", where, key)
+			}
+			setKey(rule, key, toList(getKey(value, "paths")))
+		}
+	}
+	return node
+}
+
+func normalizeRetry(node *yaml.Node, where string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return node
+	}
+	if len(node.Content) > 2 {
+		log.Warnf("%s: only retry:max is supported", where)
+	}
+	return getKey(node, "max")
+}
+
+// normalizeSecrets expands the short vault form, path/to/secret/field@engine.
+func normalizeSecrets(node *yaml.Node) *yaml.Node {
+	for i := 1; i < len(node.Content); i += 2 {
+		vault := getKey(node.Content[i], "vault")
+		if vault == nil || vault.Kind != yaml.ScalarNode {
+			continue
+		}
+
+		secret, enginePath := vault.Value, "kv-v2"
+		if at := strings.LastIndex(secret, "@"); at >= 0 {
+			secret, enginePath = secret[:at], secret[at+1:]
+		}
+		path, field := secret, ""
+		if slash := strings.LastIndex(secret, "/"); slash >= 0 {
+			path, field = secret[:slash], secret[slash+1:]
+		}
+
+		engine := mapping("name", scalar("kv-v2"))
+		setKey(engine, "path", scalar(enginePath))
+		expanded := mapping("engine", engine)
+		setKey(expanded, "path", scalar(path))
+		setKey(expanded, "field", scalar(field))
+		setKey(node.Content[i], "vault", expanded)
+	}
+	return node
+}
+
+// flattenScript turns a script into a flat list of lines. Nested lists come
+// from YAML anchors and are flattened the same way GitLab does.
+func flattenScript(node *yaml.Node) *yaml.Node {
+	if node.Tag == "!reference" {
+		node = sequence(node)
+	}
+	node = toList(node)
+
+	lines := []*yaml.Node{}
+	for _, line := range node.Content {
+		if line.Kind == yaml.SequenceNode && line.Tag != "!reference" {
+			lines = append(lines, flattenScript(line).Content...)
+			continue
+		}
+		lines = append(lines, line)
+	}
+	node.Content = lines
+
+	return node
+}
+
+// resolve returns a copy of node with aliases replaced by the nodes they point
+// at and merge keys (<<) applied, so the rest of the parser only sees plain
+// mappings, sequences and scalars.
+func resolve(node *yaml.Node) (*yaml.Node, error) {
+	switch node.Kind {
+	case yaml.AliasNode:
+		return resolve(node.Alias)
+	case yaml.SequenceNode:
+		out := *node
+		out.Content = make([]*yaml.Node, len(node.Content))
+		for i, item := range node.Content {
+			item, err := resolve(item)
+			if err != nil {
+				return nil, err
+			}
+			out.Content[i] = item
+		}
+		return &out, nil
+	case yaml.MappingNode:
+		out := *node
+		out.Content = []*yaml.Node{}
+		merges := []*yaml.Node{}
+
+		for i := 0; i < len(node.Content); i += 2 {
+			key := node.Content[i]
+			value, err := resolve(node.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+
+			if key.Kind == yaml.ScalarNode && key.Value == "<<" && (key.Tag == "" || key.Tag == "!!merge") {
+				if value.Kind == yaml.SequenceNode {
+					merges = append(merges, value.Content...)
+				} else {
+					merges = append(merges, value)
+				}
+				continue
+			}
+			out.Content = append(out.Content, key, value)
+		}
+
+		// Explicit keys win over merged ones, and earlier merges win over
+		// later ones.
+		for _, merge := range merges {
+			for i := 0; i < len(merge.Content); i += 2 {
+				if getKey(&out, merge.Content[i].Value) == nil {
+					out.Content = append(out.Content, merge.Content[i], merge.Content[i+1])
+				}
+			}
+		}
+		return &out, nil
+	default:
+		out := *node
+		return &out, nil
+	}
+}
+
+// warnUnknown logs every key in node that has no matching yaml field in v.
+func warnUnknown(node *yaml.Node, v any, where string) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+
+	known := map[string]bool{}
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		if field := t.Field(i); field.IsExported() {
+			known[yamlName(field)] = true
+		}
+	}
+
+	for i := 0; i < len(node.Content); i += 2 {
+		if key := node.Content[i].Value; !known[key] {
+			log.Warnf("%s: %s is not supported and will be dropped", where, key)
+		}
+	}
+}
+
+// yamlName is the key yaml.v3 uses for field, "-" when it is skipped.
+func yamlName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name
+}
+
+func getKey(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setKey replaces the value of key in node, adding it when missing and
+// removing it when value is nil.
+func setKey(node *yaml.Node, key string, value *yaml.Node) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		if node.Content[i].Value != key {
+			continue
+		}
+		if value == nil {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+		} else {
+			node.Content[i+1] = value
+		}
+		return
+	}
+	if value != nil {
+		node.Content = append(node.Content, scalar(key), value)
+	}
+}
+
+// toList wraps a single scalar or mapping in a sequence.
+func toList(node *yaml.Node) *yaml.Node {
+	if node == nil || node.Kind == yaml.SequenceNode || node.ShortTag() == "!!null" {
+		return node
+	}
+	return sequence(node)
+}
+
+// toMapping turns a scalar into a mapping with the scalar under key.
+func toMapping(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.ScalarNode || node.ShortTag() == "!!null" {
+		return node
+	}
+	return mapping(key, node)
+}
+
+func scalar(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+func sequence(items ...*yaml.Node) *yaml.Node {
+	return &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: items}
+}
+
+func mapping(key string, value *yaml.Node) *yaml.Node {
+	return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{scalar(key), value}}
+}
+
+func kindName(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		return "a sequence"
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.ScalarNode:
+		return "a scalar"
+	}
+	return "nothing"
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
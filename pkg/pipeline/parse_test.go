@@ -0,0 +1,369 @@
+package pipeline
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Parsing a rendered pipeline and rendering it again gives the same file.
+func TestParseRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+	}{
+		{
+			name: "jobs and stages",
+			yaml: `
+stages: [build, test, deploy]
+build:
+  stage: build
+  image: golang:1.22
+  script: [go build ./...]
+  artifacts:
+    paths: [bin/]
+    expire_in: 1 week
+test:
+  stage: test
+  needs: [build]
+  script:
+    - go vet ./...
+    - go test ./...
+deploy:
+  stage: deploy
+  dependencies: [build]
+  script: make deploy
+  when: manual
+  allow_failure: false
+  retry: 0
+  environment:
+    name: production
+    url: https://example.com
+`,
+		},
+		{
+			name: "global keys",
+			yaml: `
+default:
+  image: alpine
+  tags: [docker]
+  before_script: [echo start]
+  retry: 2
+workflow:
+  rules:
+    - if: $CI_COMMIT_BRANCH == $CI_DEFAULT_BRANCH
+    - if: $CI_PIPELINE_SOURCE == "merge_request_event"
+variables:
+  GOFLAGS: -mod=readonly
+  DEPLOY:
+    value: "no"
+    description: Deploy after the build
+    options: ["yes", "no"]
+include:
+  - local: ci/base.yml
+  - project: group/templates
+    file: go.yml
+    ref: main
+cache:
+  key: go
+  paths: [.cache/]
+job:
+  script: [true]
+`,
+		},
+		{
+			name: "rules, parallel and release",
+			yaml: `
+build:
+  stage: build
+  parallel:
+    matrix:
+      - GOOS: [linux, darwin]
+        GOARCH: amd64
+  rules:
+    - if: $CI_COMMIT_TAG
+      when: always
+    - changes: [go.mod, "**/*.go"]
+      allow_failure: true
+    - exists: [Dockerfile]
+      variables:
+        IMAGE: "yes"
+  script: [go build]
+shards:
+  stage: test
+  parallel: 3
+  script: [go test]
+release:
+  stage: deploy
+  image: registry.gitlab.com/gitlab-org/release-cli:latest
+  script: [echo release]
+  release:
+    tag_name: $CI_COMMIT_TAG
+    description: Release $CI_COMMIT_TAG
+`,
+		},
+		{
+			name: "templates, extends and references",
+			yaml: `
+.go:
+  image: golang
+  variables:
+    CGO_ENABLED: "0"
+.rules:
+  rules:
+    - if: $CI_COMMIT_BRANCH
+build:
+  extends: .go
+  stage: build
+  before_script:
+    - !reference [.go, before_script]
+  script:
+    - !reference [.go, script]
+    - go build
+  rules:
+    - !reference [.rules, rules]
+  variables:
+    IMAGE: !reference [.go, image]
+`,
+		},
+		{
+			name: "needs, inherit and image",
+			yaml: `
+build:
+  stage: build
+  image:
+    name: gcr.io/kaniko-project/executor:debug
+    entrypoint: [""]
+  needs: []
+  script: [build]
+test:
+  stage: test
+  image:
+    name: golang
+    entrypoint: /bin/sh
+  needs:
+    - build
+    - job: lint
+      optional: true
+    - project: group/project
+      job: build
+      ref: main
+      artifacts: false
+  dependencies: []
+  inherit:
+    default: false
+    variables: [GOFLAGS]
+  script: [test]
+deploy:
+  stage: deploy
+  inherit:
+    default: [image]
+    variables: false
+  script: [deploy]
+`,
+		},
+		{
+			name: "anchors",
+			yaml: `
+.defaults: &defaults
+  image: golang
+  tags: [docker]
+build:
+  <<: *defaults
+  script: [go build]
+test:
+  <<: *defaults
+  tags: [shell]
+  script: [go test]
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			first, err := Parse([]byte(test.yaml))
+			if err != nil {
+				t.Fatal(err)
+			}
+			first.SetDeterministic(true)
+			rendered, err := first.RenderE()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			second, err := Parse([]byte(rendered))
+			if err != nil {
+				t.Fatalf("rendered pipeline does not parse: %v\n%s", err, rendered)
+			}
+			second.SetDeterministic(true)
+			again, err := second.RenderE()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again != rendered {
+				t.Errorf("render changed after a round trip\nfirst:\n%s\nsecond:\n%s", rendered, again)
+			}
+			if diff := Diff(first, second); !diff.Empty() {
+				t.Errorf("parsed pipelines differ:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	p, err := Parse([]byte(`
+stages: [lint, build]
+variables:
+  A: a
+.template:
+  script: [hidden]
+.anchor: &anchor
+  - not a job
+build:
+  stage: build
+  image:
+    name: golang
+    entrypoint: [""]
+  script: go build
+  tags: docker
+  extends: .template
+lint:
+  stage: lint
+  script: [[nested, lines], last]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stages := []string{}
+	jobs := []string{}
+	for _, stage := range p.Stages {
+		stages = append(stages, stage.Name)
+		for _, job := range stage.Jobs {
+			jobs = append(jobs, job.Name)
+		}
+	}
+	if want := []string{"lint", "build"}; !equalStrings(stages, want) {
+		t.Errorf("stages %v, want %v", stages, want)
+	}
+	if want := []string{"lint", "build"}; !equalStrings(jobs, want) {
+		t.Errorf("jobs %v, want %v", jobs, want)
+	}
+	if len(p.Templates) != 1 || p.Templates[0].Name != ".template" {
+		t.Errorf("templates %v, want [.template]", p.Templates)
+	}
+	if p.Variables["A"] == nil || p.Variables["A"].Value != "a" {
+		t.Errorf("variable A = %v, want a", p.Variables["A"])
+	}
+
+	build := p.lookup("build")
+	if build.Image == nil || build.Image.Name != "golang" || !reflect.DeepEqual(build.Image.EntrypointArgs, []string{""}) {
+		t.Errorf("image %+v", build.Image)
+	}
+	if !reflect.DeepEqual(build.Script, []string{"go build"}) {
+		t.Errorf("script %v, want [go build]", build.Script)
+	}
+	if !reflect.DeepEqual(build.Tags, []string{"docker"}) {
+		t.Errorf("tags %v, want [docker]", build.Tags)
+	}
+	if !reflect.DeepEqual(build.Extends, []string{".template"}) {
+		t.Errorf("extends %v, want [.template]", build.Extends)
+	}
+	if build.Needs != nil || build.zeros["needs"] {
+		t.Errorf("needs %v, want none", build.Needs)
+	}
+	if lint := p.lookup("lint"); !reflect.DeepEqual(lint.Script, []string{"nested", "lines", "last"}) {
+		t.Errorf("lint script %v, want [nested lines last]", lint.Script)
+	}
+}
+
+func TestParseNeeds(t *testing.T) {
+	p, err := Parse([]byte(`
+build:
+  stage: build
+  needs: []
+  dependencies: []
+  script: [a]
+test:
+  stage: test
+  needs: [build, {job: lint, optional: true}]
+  inherit:
+    variables: true
+  script: [a]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	build := p.lookup("build")
+	if build.Needs == nil || len(build.Needs) != 0 || build.Dependencies == nil || len(build.Dependencies) != 0 {
+		t.Errorf("needs %#v and dependencies %#v, want empty", build.Needs, build.Dependencies)
+	}
+	out, err := MarshalE("build", build)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"dependencies: []", "needs: []"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("%s not rendered:\n%s", want, out)
+		}
+	}
+
+	test := p.lookup("test")
+	if !reflect.DeepEqual(test.Needs, []string{"build"}) {
+		t.Errorf("needs %v, want [build]", test.Needs)
+	}
+	if len(test.DetailedNeeds) != 1 || test.DetailedNeeds[0].Job != "lint" || !test.DetailedNeeds[0].Optional {
+		t.Errorf("detailed needs %+v, want lint optional", test.DetailedNeeds)
+	}
+	if !test.Inherit.Variables || test.Inherit.VariableNames != nil {
+		t.Errorf("inherit %+v, want variables: true", test.Inherit)
+	}
+}
+
+// Pipelines built with the builder render the way they always have.
+func TestRenderVariables(t *testing.T) {
+	p := NewPipeline("test")
+	p.AddVariable("A", "a", "")
+	p.AddVariable("B", "b", "choose", "b", "c")
+
+	out, err := MarshalE("variables", p.Variables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `variables:
+    A:
+        value: a
+        description: ""
+        options: []
+    B:
+        value: b
+        description: choose
+        options:
+            - b
+            - c
+`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+	}{
+		{name: "not yaml", yaml: "a: [b"},
+		{name: "not a mapping", yaml: "- a\n- b\n"},
+		{name: "job not a mapping", yaml: "job: [a]\n"},
+		{name: "bad parallel", yaml: "job:\n  script: [a]\n  parallel: many\n"},
+		{name: "undeclared stage", yaml: "stages: [build]\njob:\n  stage: test\n  script: [a]\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Parse([]byte(test.yaml)); err == nil {
+				t.Fatal("parsed")
+			}
+		})
+	}
+}
@@ -2,11 +2,17 @@ package pipeline
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/google/uuid"
 	"gopkg.in/yaml.v3"
 )
 
+var (
+	// Namespace for the IDs of deterministic pipelines and workflows.
+	idNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://github.com/reflexias/gitlab-tools"))
+)
+
 type (
 	Pipeline struct {
 		id               string
@@ -15,18 +21,23 @@ type (
 		Variables        map[string]*PipelineVariable `yaml:",omitempty"`
 		TriggerVariables map[string]any               `yaml:",omitempty"`
 		Stages           []*Stage                     `yaml:",omitempty"`
-		Default          PipelineDefault              `yaml:",omitempty"`
-		Cache            []*JobCache                  `yaml:",omitempty"`
-		Workflow         PipelineWorkflow             `yaml:",omitempty"`
-		triggerStage     string
+		// Hidden jobs, named .something, that jobs extend.
+		Templates     []*Job           `yaml:",omitempty"`
+		Default       PipelineDefault  `yaml:",omitempty"`
+		Cache         []*JobCache      `yaml:",omitempty"`
+		Workflow      PipelineWorkflow `yaml:",omitempty"`
+		triggerStage  string
+		deterministic bool
 	}
 	PipelineWorkflow struct {
+		Name  string     `yaml:"name,omitempty"`
 		Rules []*JobRule `yaml:",omitempty"`
 	}
 	PipelineVariable struct {
 		Value       string
 		Description string
 		Options     []string
+		Expand      *bool `yaml:"expand,omitempty"`
 	}
 	PipelineIncludes struct {
 		// Repo     string `yaml:",omitempty"`
@@ -46,6 +57,8 @@ type (
 		Services      []Service             `yaml:"services,omitempty"`
 		Tags          []string              `yaml:"tags,omitempty"`
 		Timeout       string                `yaml:"timeout,omitempty"`
+		// !reference lines of the scripts.
+		references scriptReferences
 	}
 	PipelineDefaultRetry struct {
 		Max  int      `yaml:"max,omitempty"`
@@ -67,6 +80,7 @@ func NewPipeline(name string) *Pipeline {
 		Variables:        map[string]*PipelineVariable{},
 		TriggerVariables: map[string]any{},
 		Stages:           []*Stage{},
+		Templates:        []*Job{},
 		Cache:            []*JobCache{},
 		triggerStage:     name,
 	}
@@ -80,6 +94,13 @@ func (this *Pipeline) SetID(id string) {
 	this.id = id
 }
 
+// SetDeterministic makes Render derive the pipeline ID from the rendered
+// content instead of using a random one, so the same pipeline always renders
+// to the same bytes. See also SetID to choose the ID yourself.
+func (this *Pipeline) SetDeterministic(deterministic bool) {
+	this.deterministic = deterministic
+}
+
 func (this *Pipeline) SetTriggerStage(name string) {
 	this.triggerStage = name
 }
@@ -174,35 +195,116 @@ func (this *Pipeline) AddCache(key string, paths ...string) {
 	}
 }
 
-func (this *Pipeline) Render() (out string) {
-	out += "#################################\n"
-	out += "# " + this.Name + " (" + this.id + ")\n"
-	out += "#################################\n"
-	out += "\n"
+// A variable can also be written as a plain value.
+func (this *PipelineVariable) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		this.Value = node.Value
+		return nil
+	}
+	type variable PipelineVariable
+	return node.Decode((*variable)(this))
+}
+
+// Scripts render with their references in place, like those of jobs.
+func (this PipelineDefault) MarshalYAML() (any, error) {
+	type def PipelineDefault
+	placeholder := this
+	this.references.placeholders(placeholder.scripts())
+	node, err := encodeNode((*def)(&placeholder))
+	if err != nil {
+		return nil, err
+	}
+	this.references.marshal(node, this.scripts())
+	return node, nil
+}
+
+func (this *PipelineDefault) UnmarshalYAML(node *yaml.Node) error {
+	type def PipelineDefault
+	scripts := this.scripts()
+	rest := *node
+	rest.Content = []*yaml.Node{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if _, ok := scripts[node.Content[i].Value]; !ok {
+			rest.Content = append(rest.Content, node.Content[i], node.Content[i+1])
+		}
+	}
+	if err := rest.Decode((*def)(this)); err != nil {
+		return err
+	}
+	return this.references.unmarshal(node, scripts)
+}
+
+func (this *PipelineDefault) scripts() map[string]*[]string {
+	return map[string]*[]string{
+		"before_script": &this.BeforeScript,
+		"after_script":  &this.AfterScript,
+	}
+}
+
+// Render calls log.Fatal on any error, use RenderE to handle them instead.
+//
+// Stages and jobs render in the order they were created. Maps, such as
+// variables, secrets and id_tokens, render with their keys sorted, comparing
+// runs of digits as numbers (VAR2 before VAR10), so they are stable between
+// runs.
+func (this *Pipeline) Render() string {
+	out, err := this.RenderE()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return out
+}
+
+// RenderE renders the pipeline, collecting every problem found into Errors
+// instead of stopping at the first one.
+func (this *Pipeline) RenderE() (out string, err error) {
+	return this.render(this.deterministic)
+}
+
+// render is RenderE with the deterministic flag given by the caller, a
+// workflow passes its own so child pipelines follow it however they were
+// added.
+func (this *Pipeline) render(deterministic bool) (out string, err error) {
+	errs := Errors{}
+	marshal := func(stage *Stage, job *Job, key string, o any) string {
+		out, err := MarshalE(key, o)
+		if err != nil {
+			errs.add(this, stage, job, err)
+		}
+		return out
+	}
+	anchors := map[string]*Job{}
+	marshalJob := func(stage *Stage, job *Job) string {
+		out, err := job.marshal(anchors)
+		if err != nil {
+			errs.add(this, stage, job, err)
+		}
+		return out
+	}
 
 	out += "# Default\n"
-	out += Marshal("default", this.Default)
+	out += marshal(nil, nil, "default", this.Default)
 	out += "\n"
 
 	out += "# Workflow\n"
-	out += Marshal("workflow", this.Workflow)
+	out += marshal(nil, nil, "workflow", this.Workflow)
 	out += "\n"
 
 	if len(this.Cache) > 0 {
 		out += "# Cache\n"
-		out += Marshal("cache", this.Cache)
+		out += marshal(nil, nil, "cache", this.Cache)
 		out += "\n"
 	}
 
 	if len(this.Includes) > 0 {
 		out += "# Includes\n"
-		out += Marshal("include", this.Includes)
+		out += marshal(nil, nil, "include", this.Includes)
 		out += "\n"
 	}
 
 	if len(this.Variables) > 0 {
 		out += "# Variables\n"
-		out += Marshal("variables", this.Variables)
+		out += marshal(nil, nil, "variables", this.Variables)
 		out += "\n"
 	}
 	stages := []string{}
@@ -215,15 +317,29 @@ func (this *Pipeline) Render() (out string) {
 			job.Stage = stage.Name
 
 			if _, ok := jobsNames[job.Name]; ok {
-				log.Fatal("Duplicate job name: " + job.Name)
+				errs.add(this, stage, job, ErrDuplicateJob)
 			}
 			jobsNames[job.Name] = true
 		}
 	}
 	out += "# Stages\n"
-	out += Marshal("stages", stages)
+	out += marshal(nil, nil, "stages", stages)
 	out += "\n"
 
+	if len(this.Templates) > 0 {
+		out += "#################################\n"
+		out += "# Templates\n"
+		for _, template := range this.Templates {
+			if _, ok := jobsNames[template.Name]; ok {
+				errs.add(this, nil, template, ErrDuplicateJob)
+			}
+			jobsNames[template.Name] = true
+
+			out += marshalJob(nil, template)
+			out += "\n"
+		}
+	}
+
 	out += "#################################\n"
 	out += "# Jobs\n"
 	for _, stage := range this.Stages {
@@ -235,21 +351,52 @@ func (this *Pipeline) Render() (out string) {
 
 			log.Debug("Rendering job: " + job.Name)
 
-			out += Marshal(job.Name, job)
+			out += marshalJob(stage, job)
 			out += "\n"
 		}
 	}
 
-	return
+	if deterministic {
+		this.id = contentID(out)
+	}
+	header := "#################################\n"
+	header += "# " + this.Name + " (" + this.id + ")\n"
+	header += "#################################\n"
+	header += "\n"
+
+	return header + out, errs.err()
 }
 
+// Marshal calls log.Fatal on any error, use MarshalE to handle it instead.
 func Marshal(key string, o any) string {
+	out, err := MarshalE(key, o)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return out
+}
+
+// contentID is a name based UUID of content, the same content always gets the
+// same ID.
+func contentID(content ...string) string {
+	return uuid.NewSHA1(idNamespace, []byte(strings.Join(content, "\x00"))).String()
+}
+
+// MarshalE renders o under key. yaml.v3 panics on values it can't encode,
+// such as funcs and channels, so those are turned into errors too.
+func MarshalE(key string, o any) (out string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
 	data := map[string]any{}
 	data[key] = o
 
-	out, err := yaml.Marshal(data)
+	raw, err := yaml.Marshal(data)
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
-	return string(out)
+	return string(raw), nil
 }
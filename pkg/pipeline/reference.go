@@ -0,0 +1,242 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MaxReferenceDepth is how many levels of !reference GitLab follows.
+const MaxReferenceDepth = 10
+
+var (
+	ErrUnknownReference = errors.New("!reference to unknown key")
+	ErrReferenceDepth   = errors.New("!reference nested too deep")
+)
+
+type (
+	// Reference is a !reference tag, the path to a key of a job or template:
+	// NewReference(".setup", "script") renders as !reference [.setup, script].
+	// It goes in scripts with AddCommandReference and the like, in rules as
+	// JobRule.Reference and in variables as the value.
+	Reference []string
+	// scriptReferences are the references in the scripts of a job or of
+	// default by key, kept apart so scripts stay lists of commands.
+	scriptReferences map[string][]scriptReference
+	// scriptReference goes before the command at index at, or after the last
+	// one.
+	scriptReference struct {
+		at        int
+		reference Reference
+	}
+)
+
+func NewReference(path ...string) Reference {
+	return Reference(path)
+}
+
+// AddCommandReference adds the lines at path, such as .setup script, to the
+// script.
+func (this *Job) AddCommandReference(path ...string) {
+	this.references.add("script", len(this.Script), NewReference(path...))
+}
+
+// AddBeforeCommandReference adds the lines at path to before_script.
+func (this *Job) AddBeforeCommandReference(path ...string) {
+	this.references.add("before_script", len(this.BeforeScript), NewReference(path...))
+}
+
+// AddAfterCommandReference adds the lines at path to after_script.
+func (this *Job) AddAfterCommandReference(path ...string) {
+	this.references.add("after_script", len(this.AfterScript), NewReference(path...))
+}
+
+// AddRuleReference adds the rules at path, such as .rules rules.
+func (this *Job) AddRuleReference(path ...string) {
+	this.Rules = append(this.Rules, &JobRule{Reference: NewReference(path...)})
+}
+
+// String is the tag as it is written in YAML.
+func (this Reference) String() string {
+	out, err := yaml.Marshal(this.node())
+	if err != nil {
+		return "!reference []"
+	}
+	return strings.TrimSuffix(string(out), "\n")
+}
+
+func (this Reference) MarshalYAML() (any, error) {
+	return this.node(), nil
+}
+
+func (this *Reference) UnmarshalYAML(node *yaml.Node) error {
+	path := []string{}
+	if err := node.Decode(&path); err != nil {
+		return err
+	}
+	*this = path
+	return nil
+}
+
+func (this Reference) node() *yaml.Node {
+	node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!reference", Style: yaml.FlowStyle}
+	for _, key := range this {
+		node.Content = append(node.Content, scalar(key))
+	}
+	return node
+}
+
+func (this *scriptReferences) add(key string, at int, reference Reference) {
+	if *this == nil {
+		*this = scriptReferences{}
+	}
+	(*this)[key] = append((*this)[key], scriptReference{at, reference})
+}
+
+// placeholders gives every script that only has references a command, so
+// omitempty keeps its key in place for marshal to fill.
+func (this scriptReferences) placeholders(scripts map[string]*[]string) {
+	for key, references := range this {
+		if lines := scripts[key]; lines != nil && len(references) > 0 && len(*lines) == 0 {
+			*lines = []string{""}
+		}
+	}
+}
+
+// marshal writes the scripts that have references into node, with every
+// reference before the command it was added before.
+func (this scriptReferences) marshal(node *yaml.Node, scripts map[string]*[]string) {
+	for key, references := range this {
+		lines := scripts[key]
+		if lines == nil || len(references) == 0 {
+			continue
+		}
+		script := sequence()
+		for i := 0; i <= len(*lines); i++ {
+			for len(references) > 0 && (references[0].at <= i || i == len(*lines)) {
+				script.Content = append(script.Content, references[0].reference.node())
+				references = references[1:]
+			}
+			if i < len(*lines) {
+				script.Content = append(script.Content, scalar((*lines)[i]))
+			}
+		}
+		setKey(node, key, script)
+	}
+}
+
+// unmarshal reads the scripts in node into their commands and references.
+// A script can be a single command and nested lists are flattened the way
+// GitLab does.
+func (this *scriptReferences) unmarshal(node *yaml.Node, scripts map[string]*[]string) error {
+	*this = nil
+	for key, lines := range scripts {
+		value := getKey(node, key)
+		if value == nil || value.ShortTag() == "!!null" {
+			continue
+		}
+
+		*lines = []string{}
+		for _, line := range flattenScript(value).Content {
+			switch {
+			case line.Tag == "!reference":
+				reference := Reference{}
+				if err := line.Decode(&reference); err != nil {
+					return err
+				}
+				this.add(key, len(*lines), reference)
+			case line.Kind == yaml.ScalarNode:
+				*lines = append(*lines, line.Value)
+			default:
+				return fmt.Errorf("line %d: %s lines must be strings", line.Line, key)
+			}
+		}
+	}
+	return nil
+}
+
+// A rule that is a Reference renders as the tag alone.
+func (this *JobRule) MarshalYAML() (any, error) {
+	if this.Reference != nil {
+		return this.Reference.node(), nil
+	}
+	type rule JobRule
+	return (*rule)(this), nil
+}
+
+func (this *JobRule) UnmarshalYAML(node *yaml.Node) error {
+	if node.Tag == "!reference" {
+		return node.Decode(&this.Reference)
+	}
+	type rule JobRule
+	return node.Decode((*rule)(this))
+}
+
+// dereference returns node with every !reference in it replaced by the value
+// it points at. A reference in a list to a list is replaced by its items,
+// the way GitLab flattens scripts and rules.
+func (this *Pipeline) dereference(node *yaml.Node, depth int) (*yaml.Node, error) {
+	if node.Tag == "!reference" {
+		return this.referenced(node, depth)
+	}
+
+	out := *node
+	switch node.Kind {
+	case yaml.SequenceNode:
+		out.Content = []*yaml.Node{}
+		for _, item := range node.Content {
+			value, err := this.dereference(item, depth)
+			if err != nil {
+				return nil, err
+			}
+			if item.Tag == "!reference" && value.Kind == yaml.SequenceNode {
+				out.Content = append(out.Content, value.Content...)
+			} else {
+				out.Content = append(out.Content, value)
+			}
+		}
+	case yaml.MappingNode:
+		out.Content = make([]*yaml.Node, len(node.Content))
+		for i := 0; i < len(node.Content); i += 2 {
+			value, err := this.dereference(node.Content[i+1], depth)
+			if err != nil {
+				return nil, err
+			}
+			out.Content[i], out.Content[i+1] = node.Content[i], value
+		}
+	}
+	return &out, nil
+}
+
+// referenced looks up the value a !reference node points at, in the job or
+// template it names after extends.
+func (this *Pipeline) referenced(node *yaml.Node, depth int) (*yaml.Node, error) {
+	ref := Reference{}
+	if err := node.Decode(&ref); err != nil {
+		return nil, err
+	}
+	if depth >= MaxReferenceDepth {
+		return nil, fmt.Errorf("%w: %s", ErrReferenceDepth, ref)
+	}
+	if len(ref) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownReference, ref)
+	}
+
+	job := this.lookup(ref[0])
+	if job == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownReference, ref)
+	}
+	value, err := this.extend(job, []string{job.Name})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ref, err)
+	}
+	for _, key := range ref[1:] {
+		if value = getKey(value, key); value == nil {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownReference, ref)
+		}
+	}
+
+	return this.dereference(value, depth+1)
+}
@@ -0,0 +1,219 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReferenceRender(t *testing.T) {
+	p := NewPipeline("test")
+	setup := p.Template("setup")
+	setup.AddCommand("make setup")
+	job := p.Stage("test").Job("test")
+	job.AddBeforeCommandReference(".setup", "script")
+	job.AddCommandReference(".setup", "script")
+	job.AddCommand("!reference [.setup, script]")
+	job.AddAfterCommandReference(".setup", "after_script")
+	job.AddRuleReference(".rules", "rules")
+	job.AddVariable("URL", NewReference(".setup", "variables", "URL"))
+
+	out, err := p.RenderE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"before_script:\n        - !reference [.setup, script]\n",
+		"script:\n        - !reference [.setup, script]\n        - '!reference [.setup, script]'\n",
+		"after_script:\n        - !reference [.setup, after_script]\n",
+		"rules:\n        - !reference [.rules, rules]\n",
+		"URL: !reference [.setup, variables, URL]\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("no %q in\n%s", want, out)
+		}
+	}
+
+	// A command that only looks like a reference stays a command.
+	parsed, err := Parse([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := parsed.lookup("test")
+	if want := []string{"!reference [.setup, script]"}; !reflect.DeepEqual(got.Script, want) {
+		t.Errorf("script %#v, want %#v", got.Script, want)
+	}
+	want := []scriptReference{{0, NewReference(".setup", "script")}}
+	if !reflect.DeepEqual(got.references["script"], want) {
+		t.Errorf("script references %#v, want %#v", got.references["script"], want)
+	}
+}
+
+func TestReferenceParse(t *testing.T) {
+	const data = `
+default:
+  before_script:
+    - !reference [.setup, script]
+.setup:
+  script: [setup]
+  after_script: [cleanup]
+  variables:
+    URL: https://example.com
+.rules:
+  rules:
+    - if: $CI_COMMIT_BRANCH
+job:
+  before_script: !reference [.setup, script]
+  script:
+    - !reference [.setup, script]
+    - - nested
+    - job
+  after_script:
+    - !reference [.setup, after_script]
+  rules:
+    - !reference [.rules, rules]
+    - when: never
+  variables:
+    URL: !reference [.setup, variables, URL]
+`
+	p, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := p.lookup("job")
+
+	tests := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"default before_script", p.Default.BeforeScript, []string{}},
+		{"default before_script references", p.Default.references["before_script"], []scriptReference{{0, NewReference(".setup", "script")}}},
+		{"before_script", job.BeforeScript, []string{}},
+		{"before_script references", job.references["before_script"], []scriptReference{{0, NewReference(".setup", "script")}}},
+		{"script", job.Script, []string{"nested", "job"}},
+		{"script references", job.references["script"], []scriptReference{{0, NewReference(".setup", "script")}}},
+		{"after_script references", job.references["after_script"], []scriptReference{{0, NewReference(".setup", "after_script")}}},
+		{"rule", job.Rules[0].Reference, NewReference(".rules", "rules")},
+		{"variable", job.Variables["URL"], NewReference(".setup", "variables", "URL")},
+	}
+	for _, test := range tests {
+		if !reflect.DeepEqual(test.got, test.want) {
+			t.Errorf("%s = %#v, want %#v", test.name, test.got, test.want)
+		}
+	}
+
+	effective, err := p.Effective(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"setup", "nested", "job"}; !reflect.DeepEqual(effective.Script, want) {
+		t.Errorf("effective script %v, want %v", effective.Script, want)
+	}
+	if want := []string{"cleanup"}; !reflect.DeepEqual(effective.AfterScript, want) {
+		t.Errorf("effective after_script %v, want %v", effective.AfterScript, want)
+	}
+	if effective.Variables["URL"] != "https://example.com" {
+		t.Errorf("effective URL %v, want https://example.com", effective.Variables["URL"])
+	}
+	if len(effective.Rules) != 2 || effective.Rules[0].If == nil || *effective.Rules[0].If != "$CI_COMMIT_BRANCH" {
+		t.Errorf("effective rules not dereferenced")
+	}
+}
+
+func TestReferenceErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		build   func(p *Pipeline) *Job
+		wantErr error
+	}{
+		{
+			name: "unknown job",
+			build: func(p *Pipeline) *Job {
+				job := p.Stage("test").Job("test")
+				job.AddCommandReference(".missing", "script")
+				return job
+			},
+			wantErr: ErrUnknownReference,
+		},
+		{
+			name: "unknown key",
+			build: func(p *Pipeline) *Job {
+				p.Template("setup").AddCommand("setup")
+				job := p.Stage("test").Job("test")
+				job.AddCommandReference(".setup", "after_script")
+				return job
+			},
+			wantErr: ErrUnknownReference,
+		},
+		{
+			name: "too deep",
+			build: func(p *Pipeline) *Job {
+				p.Template("t0").AddCommand("end")
+				for i := 1; i <= MaxReferenceDepth+1; i++ {
+					p.Template("t%d", i).AddCommandReference(fmt.Sprintf(".t%d", i-1), "script")
+				}
+				job := p.Stage("test").Job("test")
+				job.AddCommandReference(fmt.Sprintf(".t%d", MaxReferenceDepth+1), "script")
+				return job
+			},
+			wantErr: ErrReferenceDepth,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := NewPipeline("test")
+			job := test.build(p)
+			if _, err := p.Effective(job); !errors.Is(err, test.wantErr) {
+				t.Fatalf("err = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+// References render between the commands they were added between, and
+// survive parsing the rendered pipeline again.
+func TestReferenceScriptOrder(t *testing.T) {
+	p := NewPipeline("test")
+	p.SetID("id")
+	p.Default.references.add("before_script", 0, NewReference(".setup", "before_script"))
+	job := p.Stage("test").Job("test")
+	job.AddCommandReference(".setup", "first")
+	job.AddCommand("a")
+	job.AddCommandReference(".setup", "middle")
+	job.AddCommand("b")
+	job.AddCommandReference(".setup", "last")
+	job.AddAfterCommandReference(".setup", "after_script")
+
+	out, err := p.RenderE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "script:\n        - !reference [.setup, first]\n        - a\n        - !reference [.setup, middle]\n        - b\n        - !reference [.setup, last]\n"
+	if !strings.Contains(out, want) {
+		t.Errorf("no %q in\n%s", want, out)
+	}
+	if !strings.Contains(out, "before_script:\n        - !reference [.setup, before_script]\n") {
+		t.Errorf("default before_script not rendered in\n%s", out)
+	}
+	if strings.Index(out, "after_script:") < strings.Index(out, "script:\n        - !reference [.setup, first]") {
+		t.Errorf("after_script rendered before script in\n%s", out)
+	}
+
+	parsed, err := Parse([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed.Name = "test"
+	parsed.SetID("id")
+	again, err := parsed.RenderE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != out {
+		t.Errorf("rendered again\n%s\nwant\n%s", again, out)
+	}
+}
@@ -0,0 +1,109 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The image GitLab documents for release jobs, it ships release-cli.
+const ReleaseCLIImage = "registry.gitlab.com/gitlab-org/release-cli:latest"
+
+type (
+	// https://docs.gitlab.com/ee/ci/yaml/#release
+	Release struct {
+		TagName     string         `yaml:"tag_name"`
+		TagMessage  string         `yaml:"tag_message,omitempty"`
+		Name        string         `yaml:"name,omitempty"`
+		Description string         `yaml:"description"`
+		Ref         string         `yaml:"ref,omitempty"`
+		Milestones  []string       `yaml:"milestones,omitempty"`
+		ReleasedAt  string         `yaml:"released_at,omitempty"`
+		Assets      *ReleaseAssets `yaml:"assets,omitempty"`
+	}
+	ReleaseAssets struct {
+		Links []*ReleaseLink `yaml:"links"`
+	}
+	// LinkType is other, runbook, image or package.
+	ReleaseLink struct {
+		Name            string `yaml:"name"`
+		URL             string `yaml:"url"`
+		DirectAssetPath string `yaml:"direct_asset_path,omitempty"`
+		LinkType        string `yaml:"link_type,omitempty"`
+	}
+)
+
+// ReleaseJob.SetRelease("$CI_COMMIT_TAG", "Release $CI_COMMIT_TAG").AddMilestones("v1.0")
+func (this *Job) SetRelease(tagName, description string) *Release {
+	this.Release = &Release{
+		TagName:     tagName,
+		Description: description,
+	}
+	return this.Release
+}
+
+func (this *Release) SetName(format string, a ...any) *Release {
+	this.Name = fmt.Sprintf(format, a...)
+	return this
+}
+
+// Message for the annotated tag created when the tag does not exist yet.
+func (this *Release) SetTagMessage(message string) *Release {
+	this.TagMessage = message
+	return this
+}
+
+// Commit SHA, tag or branch to create the tag from when it does not exist.
+func (this *Release) SetRef(ref string) *Release {
+	this.Ref = ref
+	return this
+}
+
+func (this *Release) AddMilestones(milestones ...string) *Release {
+	this.Milestones = append(this.Milestones, milestones...)
+	return this
+}
+
+// ISO 8601, 2024-01-31T12:00:00Z
+func (this *Release) SetReleasedAt(releasedAt string) *Release {
+	this.ReleasedAt = releasedAt
+	return this
+}
+
+// Release.AddLink("binary", "https://example.com/bin", "package")
+func (this *Release) AddLink(name, url, linkType string) *Release {
+	if this.Assets == nil {
+		this.Assets = &ReleaseAssets{}
+	}
+	this.Assets.Links = append(this.Assets.Links, &ReleaseLink{
+		Name:     name,
+		URL:      url,
+		LinkType: linkType,
+	})
+	return this
+}
+
+// validate checks the keys GitLab requires and that the job can run, either
+// with a script of its own or with the release-cli image.
+func (this *Release) validate(job *Job) []error {
+	errs := []error{}
+	if this.TagName == "" {
+		errs = append(errs, fmt.Errorf("%w: tag_name is required", ErrInvalidRelease))
+	}
+	if this.Description == "" {
+		errs = append(errs, fmt.Errorf("%w: description is required", ErrInvalidRelease))
+	}
+	if this.Assets != nil {
+		for _, link := range this.Assets.Links {
+			if link.Name == "" || link.URL == "" {
+				errs = append(errs, fmt.Errorf("%w: asset links need a name and url", ErrInvalidRelease))
+			}
+		}
+	}
+
+	releaseCLI := job.Image != nil && strings.HasPrefix(job.Image.Name, strings.TrimSuffix(ReleaseCLIImage, ":latest"))
+	if len(job.Script) == 0 && !releaseCLI {
+		errs = append(errs, fmt.Errorf("%w: job needs a script or the release-cli image", ErrInvalidRelease))
+	}
+
+	return errs
+}
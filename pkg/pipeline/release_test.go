@@ -0,0 +1,118 @@
+package pipeline
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReleaseValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(job *Job)
+		want  []string
+	}{
+		{
+			name: "script",
+			build: func(job *Job) {
+				job.AddCommand("echo release")
+				job.SetRelease("$CI_COMMIT_TAG", "Release $CI_COMMIT_TAG").AddLink("binary", "https://example.com/bin", "package")
+			},
+		},
+		{
+			name: "release-cli image",
+			build: func(job *Job) {
+				job.SetImage(ReleaseCLIImage)
+				job.SetRelease("v1", "First")
+			},
+		},
+		{
+			name: "pinned release-cli image",
+			build: func(job *Job) {
+				job.SetImage("registry.gitlab.com/gitlab-org/release-cli:v0.18.0")
+				job.SetRelease("v1", "First")
+			},
+		},
+		{
+			name: "missing tag_name and description",
+			build: func(job *Job) {
+				job.AddCommand("echo release")
+				job.SetRelease("", "")
+			},
+			want: []string{"tag_name is required", "description is required"},
+		},
+		{
+			name: "incomplete links",
+			build: func(job *Job) {
+				job.AddCommand("echo release")
+				job.SetRelease("v1", "First").AddLink("", "https://example.com", "").AddLink("binary", "", "")
+			},
+			want: []string{"asset links need a name and url", "asset links need a name and url"},
+		},
+		{
+			name: "nothing to run",
+			build: func(job *Job) {
+				job.SetImage("alpine")
+				job.SetRelease("v1", "First")
+			},
+			want: []string{"job needs a script or the release-cli image"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			job := NewJob("release")
+			test.build(job)
+
+			errs := job.Release.validate(job)
+			if len(errs) != len(test.want) {
+				t.Fatalf("got %v, want %v", errs, test.want)
+			}
+			for i, want := range test.want {
+				if !errors.Is(errs[i], ErrInvalidRelease) || !strings.Contains(errs[i].Error(), want) {
+					t.Errorf("error %d = %v, want %s", i, errs[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestReleaseRender(t *testing.T) {
+	job := &Job{}
+	job.SetRelease("$CI_COMMIT_TAG", "Release $CI_COMMIT_TAG").
+		SetName("v%d", 1).
+		AddMilestones("m1").
+		AddLink("binary", "https://example.com/bin", "package")
+
+	out, err := MarshalE("job", job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `job:
+    release:
+        tag_name: $CI_COMMIT_TAG
+        name: v1
+        description: Release $CI_COMMIT_TAG
+        milestones:
+            - m1
+        assets:
+            links:
+                - name: binary
+                  url: https://example.com/bin
+                  link_type: package
+`
+	if out != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestValidateRelease(t *testing.T) {
+	p, err := Parse([]byte("job:\n  script: [a]\n  release:\n    name: v1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := Errors{}
+	if err := p.Validate(); !errors.As(err, &errs) || len(errs) != 2 || errs[0].Job != "job" {
+		t.Errorf("err = %v, want tag_name and description errors for job", err)
+	}
+}
@@ -0,0 +1,411 @@
+package pipeline
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/reflexias/gitlab-tools/internal/glob"
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	Simulation struct {
+		// Created is false when workflow rules stop the pipeline, Reason
+		// says why.
+		Created   bool
+		Reason    string
+		Variables map[string]string
+		Jobs      []*SimulatedJob
+		// Jobs left out of the pipeline, with the Reason why.
+		Skipped []*SimulatedJob
+	}
+	SimulatedJob struct {
+		Job *Job
+		// Name differs from Job.Name for parallel jobs.
+		Name         string
+		Stage        string
+		When         string
+		AllowFailure bool
+		// Index of the rule that decided, -1 when no rule did.
+		Rule      int
+		Reason    string
+		Variables map[string]string
+	}
+	// variable is a value to set and whether to expand it.
+	variable struct {
+		value  string
+		expand bool
+	}
+	simulator struct {
+		fsys    fs.FS
+		changes []string
+		files   []string
+	}
+)
+
+// Simulate works out which jobs GitLab would create for a pipeline with the
+// given CI variables and changed files, using the current directory for
+// rules:exists. See SimulateFS.
+func (this *Pipeline) Simulate(vars map[string]string, changedFiles []string) (*Simulation, error) {
+	return this.SimulateFS(os.DirFS("."), vars, changedFiles)
+}
+
+// SimulateFS applies the workflow rules and then every job's rules, the first
+// matching rule deciding when and allow_failure. Variables are layered from
+// lowest to highest: pipeline variables, workflow rule variables, job
+// variables, job rule variables and finally vars, like predefined and trigger
+// variables in GitLab. Variables defined in the pipeline can refer to others
+// with $NAME, on the same level too, and expand the same on every run. Jobs
+// are simulated after extends. A nil changedFiles makes rules:changes always
+// match, as GitLab does for pipelines without a push, and rules:exists is
+// checked against fsys.
+//
+// Problems with single jobs don't stop the simulation, they are returned as
+// Errors with the Simulation of the rest. A job whose extends can't be
+// resolved is simulated as it is written, a job whose rules can't be
+// evaluated is left out.
+func (this *Pipeline) SimulateFS(fsys fs.FS, vars map[string]string, changedFiles []string) (*Simulation, error) {
+	sim := &simulator{fsys: fsys, changes: changedFiles}
+	result := &Simulation{
+		Created:   true,
+		Variables: map[string]string{},
+		Jobs:      []*SimulatedJob{},
+		Skipped:   []*SimulatedJob{},
+	}
+
+	layer(result.Variables, vars)
+	pipelineVariables := map[string]variable{}
+	for name, v := range this.Variables {
+		if _, ok := vars[name]; ok {
+			continue
+		}
+		pipelineVariables[name] = variable{v.Value, v.Expand == nil || *v.Expand}
+	}
+	expandVariables(result.Variables, pipelineVariables)
+
+	if len(this.Workflow.Rules) > 0 {
+		rules, err := this.dereferenceRules(this.Workflow.Rules)
+		if err != nil {
+			return nil, fmt.Errorf("workflow: %w", err)
+		}
+		index, rule, err := sim.match(rules, result.Variables)
+		if err != nil {
+			return nil, fmt.Errorf("workflow: %w", err)
+		}
+		switch {
+		case rule == nil:
+			result.Created = false
+			result.Reason = "no workflow rule matched"
+		case rule.When != nil && *rule.When == "never":
+			result.Created = false
+			result.Reason = fmt.Sprintf("workflow rule %d has when: never", index)
+		default:
+			for name, value := range rule.Variables {
+				result.Variables[name] = value
+			}
+		}
+		if !result.Created {
+			return result, nil
+		}
+	}
+	layer(result.Variables, vars)
+
+	errs := Errors{}
+	for _, stage := range this.Stages {
+		for _, job := range stage.Jobs {
+			effective, err := this.Effective(job)
+			if err != nil {
+				// Such as a template from an include that wasn't resolved,
+				// the rest of the pipeline can still be simulated.
+				errs.add(this, stage, job, err)
+				effective = job
+			}
+			for _, instance := range effective.ExpandParallel() {
+				simulated, err := sim.job(effective, result.Variables, instance.Variables, vars)
+				if err != nil {
+					errs.add(this, stage, job, err)
+					continue
+				}
+				simulated.Name = instance.Name
+				simulated.Stage = stage.Name
+
+				if simulated.When == "never" {
+					result.Skipped = append(result.Skipped, simulated)
+				} else {
+					result.Jobs = append(result.Jobs, simulated)
+				}
+			}
+		}
+	}
+
+	return result, errs.err()
+}
+
+func (this *simulator) job(job *Job, global, matrix, vars map[string]string) (*SimulatedJob, error) {
+	env := map[string]string{}
+	layer(env, global)
+	jobVariables := map[string]variable{}
+	for name, value := range job.Variables {
+		jobVariables[name] = jobVariable(value)
+	}
+	expandVariables(env, jobVariables)
+	layer(env, matrix)
+	layer(env, vars)
+
+	simulated := &SimulatedJob{
+		Job:       job,
+		Rule:      -1,
+		Variables: env,
+	}
+
+	when := job.When
+	if when == "" {
+		when = "on_success"
+	}
+
+	if len(job.Rules) == 0 {
+		simulated.When = when
+		// Only manual jobs without rules are allowed to fail by default.
+		simulated.AllowFailure = when == "manual"
+		if job.allowFailureSet() {
+			simulated.AllowFailure = job.AllowFailure
+		}
+		simulated.Reason = "job has no rules"
+		return simulated, nil
+	}
+
+	index, rule, err := this.match(job.Rules, env)
+	if err != nil {
+		return nil, err
+	}
+	if rule == nil {
+		simulated.When = "never"
+		simulated.Reason = "no rule matched"
+		return simulated, nil
+	}
+
+	simulated.Rule = index
+	simulated.Reason = fmt.Sprintf("rule %d matched", index)
+	simulated.When = when
+	if rule.When != nil {
+		simulated.When = *rule.When
+	}
+	simulated.AllowFailure = job.AllowFailure
+	if rule.AllowFailure != nil {
+		simulated.AllowFailure = *rule.AllowFailure
+	}
+	for name, value := range rule.Variables {
+		env[name] = value
+	}
+	layer(env, vars)
+
+	return simulated, nil
+}
+
+// match returns the first rule whose if, changes and exists all hold.
+func (this *simulator) match(rules []*JobRule, env map[string]string) (int, *JobRule, error) {
+	for i, rule := range rules {
+		if rule.If != nil {
+			ok, err := Evaluate(*rule.If, env)
+			if err != nil {
+				return -1, nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		if len(rule.Changes) > 0 && this.changes != nil && !matchAny(expandAll(rule.Changes, env), this.changes) {
+			continue
+		}
+
+		if len(rule.Exists) > 0 {
+			files, err := this.repositoryFiles()
+			if err != nil {
+				return -1, nil, err
+			}
+			if !matchAny(expandAll(rule.Exists, env), files) {
+				continue
+			}
+		}
+
+		return i, rule, nil
+	}
+	return -1, nil, nil
+}
+
+// dereferenceRules replaces the references among rules with the rules they
+// point at.
+func (this *Pipeline) dereferenceRules(rules []*JobRule) ([]*JobRule, error) {
+	node := &yaml.Node{}
+	if err := node.Encode(rules); err != nil {
+		return nil, err
+	}
+	node, err := this.dereference(node, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []*JobRule{}
+	return out, node.Decode(&out)
+}
+
+// repositoryFiles lists fsys once, the first time a rule needs it.
+func (this *simulator) repositoryFiles() ([]string, error) {
+	if this.files != nil {
+		return this.files, nil
+	}
+
+	this.files = []string{}
+	err := fs.WalkDir(this.fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return fs.SkipDir
+		}
+		if !d.IsDir() {
+			this.files = append(this.files, path)
+		}
+		return nil
+	})
+	return this.files, err
+}
+
+func matchAny(patterns, paths []string) bool {
+	for _, pattern := range patterns {
+		for _, path := range paths {
+			if glob.Match(pattern, strings.TrimPrefix(path, "./")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func expandAll(patterns []string, env map[string]string) []string {
+	out := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		out[i] = expand(pattern, env)
+	}
+	return out
+}
+
+// expand replaces $NAME and ${NAME} with their values, leaving unknown
+// variables as written.
+func expand(s string, env map[string]string) string {
+	return expandFunc(s, func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	})
+}
+
+// expandFunc replaces $NAME and ${NAME} with the value lookup finds for NAME.
+// Anything else, unknown variables included, is left exactly as written.
+func expandFunc(s string, lookup func(name string) (string, bool)) string {
+	out := strings.Builder{}
+	for i := 0; i < len(s); {
+		if s[i] != '$' {
+			out.WriteByte(s[i])
+			i++
+			continue
+		}
+
+		name, end := "", i+1
+		if end < len(s) && s[end] == '$' {
+			// $$ is an escaped $, the name after it is not a variable.
+			out.WriteString("$$")
+			i += 2
+			continue
+		}
+		if end < len(s) && s[end] == '{' {
+			if close := strings.IndexByte(s[end:], '}'); close > 0 {
+				name, end = s[end+1:end+close], end+close+1
+			}
+		} else {
+			for end < len(s) && isVariableChar(s[end]) {
+				end++
+			}
+			name = s[i+1 : end]
+		}
+
+		if value, ok := lookup(name); ok && name != "" {
+			out.WriteString(value)
+		} else {
+			out.WriteString(s[i:end])
+		}
+		i = end
+	}
+	return out.String()
+}
+
+// expandVariables sets vars over env, expanding the values that expand
+// against env and the other vars. Values are resolved in sorted order and a
+// value referring to another one in vars gets that one expanded first, so
+// the result never depends on map order. A reference back to a variable
+// being resolved, such as PATH: $PATH:/bin, gets the value from env.
+func expandVariables(env map[string]string, vars map[string]variable) {
+	resolved := map[string]string{}
+	resolving := map[string]bool{}
+
+	var resolve func(name string) string
+	resolve = func(name string) string {
+		if value, ok := resolved[name]; ok {
+			return value
+		}
+		v := vars[name]
+		if !v.expand {
+			return v.value
+		}
+
+		resolving[name] = true
+		value := expandFunc(v.value, func(ref string) (string, bool) {
+			if _, ok := vars[ref]; ok && !resolving[ref] {
+				return resolve(ref), true
+			}
+			value, ok := env[ref]
+			return value, ok
+		})
+		resolving[name] = false
+
+		resolved[name] = value
+		return value
+	}
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	values := map[string]string{}
+	for _, name := range names {
+		values[name] = resolve(name)
+	}
+	layer(env, values)
+}
+
+// layer copies vars over env.
+func layer(env, vars map[string]string) {
+	for name, value := range vars {
+		env[name] = value
+	}
+}
+
+// Job variables are either a value or a mapping with value and expand keys.
+func jobVariable(value any) variable {
+	v := variable{expand: true}
+	if m, ok := value.(map[string]any); ok {
+		value = m["value"]
+		if expand, ok := m["expand"].(bool); ok {
+			v.expand = expand
+		}
+	}
+	if value != nil {
+		v.value = fmt.Sprint(value)
+	}
+	return v
+}
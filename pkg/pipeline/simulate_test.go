@@ -0,0 +1,161 @@
+package pipeline
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSimulateVariables(t *testing.T) {
+	tests := []struct {
+		name     string
+		pipeline map[string]string
+		job      map[string]any
+		vars     map[string]string
+		want     map[string]string
+	}{
+		{
+			name:     "pipeline variable refers to a later one",
+			pipeline: map[string]string{"A": "$B-a", "B": "b"},
+			want:     map[string]string{"A": "b-a", "B": "b"},
+		},
+		{
+			name:     "pipeline variable refers to an earlier one",
+			pipeline: map[string]string{"Y": "$X-y", "X": "x"},
+			want:     map[string]string{"X": "x", "Y": "x-y"},
+		},
+		{
+			name:     "chain",
+			pipeline: map[string]string{"A": "$B", "B": "$C", "C": "$D", "D": "d"},
+			want:     map[string]string{"A": "d", "B": "d", "C": "d", "D": "d"},
+		},
+		{
+			name:     "job variables refer to each other and the pipeline",
+			pipeline: map[string]string{"REGISTRY": "registry.example.com"},
+			job:      map[string]any{"TAG": "$IMAGE:$VERSION", "IMAGE": "$REGISTRY/app", "VERSION": "1"},
+			want:     map[string]string{"REGISTRY": "registry.example.com", "IMAGE": "registry.example.com/app", "VERSION": "1", "TAG": "registry.example.com/app:1"},
+		},
+		{
+			name:     "job variable refers to itself",
+			pipeline: map[string]string{"FLAGS": "-v"},
+			job:      map[string]any{"FLAGS": "$FLAGS -race"},
+			want:     map[string]string{"FLAGS": "-v -race"},
+		},
+		{
+			name: "cycle stays as written",
+			job:  map[string]any{"A": "$B", "B": "$A"},
+			want: map[string]string{"A": "$A", "B": "$A"},
+		},
+		{
+			name: "expand false",
+			job:  map[string]any{"A": map[string]any{"value": "$B", "expand": false}, "B": "b", "C": "$A"},
+			want: map[string]string{"A": "$B", "B": "b", "C": "$B"},
+		},
+		{
+			name:     "vars win",
+			pipeline: map[string]string{"A": "$B", "B": "b"},
+			vars:     map[string]string{"B": "override"},
+			want:     map[string]string{"A": "override", "B": "override"},
+		},
+		{
+			name: "unknown variables stay",
+			job:  map[string]any{"A": "$UNKNOWN"},
+			want: map[string]string{"A": "$UNKNOWN"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := NewPipeline("test")
+			for name, value := range test.pipeline {
+				p.AddVariable(name, value, "")
+			}
+			job := p.Stage("test").Job("job")
+			job.AddCommand("true")
+			for name, value := range test.job {
+				job.AddVariable(name, value)
+			}
+
+			sim, err := p.SimulateFS(fstest.MapFS{}, test.vars, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sim.Jobs) != 1 {
+				t.Fatalf("got %d jobs, want 1", len(sim.Jobs))
+			}
+			got := sim.Jobs[0].Variables
+			for name, want := range test.want {
+				if got[name] != want {
+					t.Errorf("%s = %q, want %q", name, got[name], want)
+				}
+			}
+		})
+	}
+}
+
+// Map order is random, the simulation must not be.
+func TestSimulateDeterministic(t *testing.T) {
+	p := NewPipeline("test")
+	p.AddVariable("A", "a", "")
+	p.AddVariable("B", "$A-b", "")
+	p.AddVariable("C", "$B-c", "")
+	p.AddVariable("D", "$C-$E", "")
+	p.AddVariable("E", "$A$A", "")
+	job := p.Stage("test").Job("job")
+	job.AddCommand("true")
+	job.AddVariable("F", "$D-$G")
+	job.AddVariable("G", "$H")
+	job.AddVariable("H", "$C")
+	job.AddVariable("I", "$I-$J")
+	job.AddVariable("J", "$I")
+	job.AddIfRule(`$F == "a-b-c-aa-a-b-c"`)
+
+	var first *Simulation
+	for i := 0; i < 100; i++ {
+		sim, err := p.SimulateFS(fstest.MapFS{}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first == nil {
+			first = sim
+			if len(sim.Jobs) != 1 {
+				t.Fatalf("rule did not match: %v", sim.Skipped[0].Variables)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(sim.Variables, first.Variables) {
+			t.Fatalf("run %d: pipeline variables %v, first run %v", i, sim.Variables, first.Variables)
+		}
+		if !reflect.DeepEqual(sim.Jobs[0].Variables, first.Jobs[0].Variables) {
+			t.Fatalf("run %d: job variables %v, first run %v", i, sim.Jobs[0].Variables, first.Jobs[0].Variables)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	env := map[string]string{"A": "a", "EMPTY": "", "DIR": "src"}
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"$A", "a"},
+		{"${A}", "a"},
+		{"$A/$DIR/${DIR}", "a/src/src"},
+		{"x${EMPTY}y", "xy"},
+		{"$UNKNOWN", "$UNKNOWN"},
+		{"${UNKNOWN}/file", "${UNKNOWN}/file"},
+		{"${A", "${A"},
+		{"${}", "${}"},
+		{"$", "$"},
+		{"$$A", "$$A"},
+		{"cost: 5$", "cost: 5$"},
+		{"$A.txt", "a.txt"},
+	}
+
+	for _, test := range tests {
+		if got := expand(test.in, env); got != test.want {
+			t.Errorf("expand(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
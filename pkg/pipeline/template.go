@@ -0,0 +1,135 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrAnchorOrder     = errors.New("merges a template rendered after it")
+	ErrDuplicateAnchor = errors.New("duplicate anchor")
+	ErrCyclicMerge     = errors.New("merge cycle")
+
+	anchorChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+)
+
+// Template creates a hidden job, rendered as .name without a stage, for jobs
+// to extend with ExtendJob or merge with MergeJob. The leading dot is added
+// when name has none.
+func (this *Pipeline) Template(format string, a ...any) *Job {
+	name := fmt.Sprintf(format, a...)
+	if !strings.HasPrefix(name, ".") {
+		name = "." + name
+	}
+
+	template := NewJob("%s", name)
+	this.Templates = append(this.Templates, template)
+	return template
+}
+
+// ExtendJob extends j, a template or another job, see Pipeline.Effective.
+func (this *Job) ExtendJob(j *Job) {
+	this.Extends = append(this.Extends, j.Name)
+}
+
+// MergeJob copies the keys of j, a template, into the job with a YAML anchor
+// and merge key: j renders as .name: &name and the job with <<: *name. Keys
+// set on the job win and, unlike extends, nothing is merged deeper than the
+// top level. The anchor is the name with anything but letters, digits, _ and
+// - replaced, templates that end up with the same one, such as .a.b and .a_b,
+// fail to render with ErrDuplicateAnchor.
+func (this *Job) MergeJob(j *Job) {
+	j.anchor = anchorChars.ReplaceAllString(strings.TrimPrefix(j.Name, "."), "_")
+	this.merges = append(this.merges, j)
+}
+
+// marshal renders the job under its name, with its anchor and a merge key
+// for the templates it merges. Anchors must be defined before they are used
+// and only once, defined holds the job each anchor rendered so far belongs
+// to.
+func (this *Job) marshal(defined map[string]*Job) (string, error) {
+	if this.anchor == "" && len(this.merges) == 0 {
+		return MarshalE(this.Name, this)
+	}
+
+	node, err := encodeNode(this)
+	if err != nil {
+		return "", err
+	}
+	if this.anchor != "" {
+		if other, ok := defined[this.anchor]; ok && other != this {
+			return "", fmt.Errorf("%w: %s and %s are both &%s", ErrDuplicateAnchor, other.Name, this.Name, this.anchor)
+		}
+		defined[this.anchor] = this
+		node.Anchor = this.anchor
+	}
+
+	aliases := []*yaml.Node{}
+	for _, merge := range this.merges {
+		if defined[merge.anchor] != merge {
+			return "", fmt.Errorf("%w: %s", ErrAnchorOrder, merge.Name)
+		}
+		aliases = append(aliases, &yaml.Node{Kind: yaml.AliasNode, Value: merge.anchor})
+	}
+	if len(aliases) > 0 {
+		value := aliases[0]
+		if len(aliases) > 1 {
+			value = &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle, Content: aliases}
+		}
+		key := &yaml.Node{Kind: yaml.ScalarNode, Value: "<<"}
+		node.Content = append([]*yaml.Node{key, value}, node.Content...)
+	}
+
+	return MarshalE(this.Name, node)
+}
+
+// encode returns job as a YAML mapping, with the keys of the templates it
+// merges added the way a merge key adds them.
+func encode(job *Job, chain ...*Job) (*yaml.Node, error) {
+	for i, j := range chain {
+		if j == job {
+			names := []string{}
+			for _, j := range append(chain[i:], job) {
+				names = append(names, j.Name)
+			}
+			return nil, fmt.Errorf("%w: %s", ErrCyclicMerge, strings.Join(names, " -> "))
+		}
+	}
+
+	node, err := encodeNode(job)
+	if err != nil {
+		return nil, err
+	}
+	for _, merge := range job.merges {
+		merged, err := encode(merge, append(chain, job)...)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(merged.Content); i += 2 {
+			if getKey(node, merged.Content[i].Value) == nil {
+				node.Content = append(node.Content, merged.Content[i], merged.Content[i+1])
+			}
+		}
+	}
+	return node, nil
+}
+
+// encodeNode encodes o into a node, turning the panics of yaml.v3 into errors
+// like MarshalE does.
+func encodeNode(o any) (node *yaml.Node, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	node = &yaml.Node{}
+	if err := node.Encode(o); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
@@ -0,0 +1,148 @@
+package pipeline
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeJob(t *testing.T) {
+	tests := []struct {
+		name    string
+		build   func(p *Pipeline)
+		want    []string
+		wantErr error
+	}{
+		{
+			name: "one template",
+			build: func(p *Pipeline) {
+				base := p.Template("go")
+				base.SetImage("golang")
+				job := p.Stage("test").Job("test")
+				job.MergeJob(base)
+				job.AddCommand("go test")
+			},
+			want: []string{".go: &go\n", "test:\n    <<: *go\n    stage: test\n"},
+		},
+		{
+			name: "several templates",
+			build: func(p *Pipeline) {
+				a := p.Template("a")
+				a.SetImage("a")
+				b := p.Template("b")
+				job := p.Stage("test").Job("test")
+				job.MergeJob(a)
+				job.MergeJob(b)
+			},
+			want: []string{".a: &a\n", ".b: &b {}\n", "    <<: [*a, *b]\n"},
+		},
+		{
+			name: "anchor names",
+			build: func(p *Pipeline) {
+				base := p.Template("go 1.22")
+				p.Stage("test").Job("test").MergeJob(base)
+			},
+			want: []string{".go 1.22: &go_1_22 {}\n", "<<: *go_1_22\n"},
+		},
+		{
+			name: "duplicate anchor",
+			build: func(p *Pipeline) {
+				a := p.Template("a.b")
+				b := p.Template("a_b")
+				p.Stage("test").Job("x").MergeJob(a)
+				p.Stage("test").Job("y").MergeJob(b)
+			},
+			wantErr: ErrDuplicateAnchor,
+		},
+		{
+			name: "template not in the pipeline",
+			build: func(p *Pipeline) {
+				p.Stage("test").Job("test").MergeJob(NewJob(".elsewhere"))
+			},
+			wantErr: ErrAnchorOrder,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := NewPipeline("test")
+			test.build(p)
+
+			out, err := p.RenderE()
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("err = %v, want %v", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("no %q in\n%s", want, out)
+				}
+			}
+
+			// GitLab reads it back with the templates merged in.
+			if _, err := Parse([]byte(out)); err != nil {
+				t.Errorf("rendered pipeline does not parse: %v\n%s", err, out)
+			}
+		})
+	}
+}
+
+func TestMergeJobEffective(t *testing.T) {
+	p := NewPipeline("test")
+	base := p.Template("base")
+	base.SetImage("golang")
+	base.AddVariable("A", "base")
+	base.AddCommand("base")
+	job := p.Stage("test").Job("test")
+	job.MergeJob(base)
+	job.AddVariable("B", "job")
+	job.AddCommand("job")
+
+	effective, err := p.Effective(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if effective.Image == nil || effective.Image.Name != "golang" {
+		t.Errorf("image %v, want golang", effective.Image)
+	}
+	// A merge key is shallow, the job's variables replace the template's.
+	if want := map[string]any{"B": "job"}; !reflect.DeepEqual(effective.Variables, want) {
+		t.Errorf("variables %v, want %v", effective.Variables, want)
+	}
+	if !reflect.DeepEqual(effective.Script, []string{"job"}) {
+		t.Errorf("script %v, want [job]", effective.Script)
+	}
+
+	// The rendered pipeline parses to the same job.
+	parsed, err := Parse([]byte(p.Render()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := parsed.Effective(parsed.lookup("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again.Variables, effective.Variables) || !reflect.DeepEqual(again.Script, effective.Script) {
+		t.Errorf("parsed job %v %v, want %v %v", again.Variables, again.Script, effective.Variables, effective.Script)
+	}
+}
+
+func TestMergeJobCycle(t *testing.T) {
+	p := NewPipeline("test")
+	a := p.Template("a")
+	b := p.Template("b")
+	a.MergeJob(b)
+	b.MergeJob(a)
+	job := p.Stage("test").Job("test")
+	job.MergeJob(a)
+
+	if _, err := p.Effective(job); !errors.Is(err, ErrCyclicMerge) {
+		t.Fatalf("err = %v, want %v", err, ErrCyclicMerge)
+	}
+}
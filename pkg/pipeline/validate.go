@@ -0,0 +1,189 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrUnknownJob     = errors.New("unknown job")
+	ErrStageOrder     = errors.New("job is not in an earlier stage")
+	ErrCyclicNeeds    = errors.New("needs cycle")
+	ErrParallel       = errors.New("parallel must create between 1 and 200 jobs")
+	ErrInvalidRelease = errors.New("invalid release")
+)
+
+// Validate checks that every needs, dependencies and environment on_stop
+// entry points at a job in this pipeline, that dependencies only point at
+// earlier stages, needs at the same or earlier stages, and that the needs
+// graph has no cycles. Parallel jobs are expanded so single instances and
+// needs:parallel:matrix can be referenced. Release jobs are checked for the
+// keys GitLab requires. Needs on other projects or pipelines can't be checked
+// locally and are skipped. Jobs are checked as they are after extends, and
+// unknown or cyclic parents are reported too.
+func (this *Pipeline) Validate() error {
+	errs := Errors{}
+	// A count below one doesn't render, so it's gone after Flatten.
+	for _, stage := range this.Stages {
+		for _, job := range stage.Jobs {
+			if job.Parallel != nil && len(job.Parallel.Matrix) == 0 && job.Parallel.Count < 1 {
+				errs.add(this, stage, job, ErrParallel)
+			}
+		}
+	}
+	flat, err := this.Flatten()
+	if err != nil {
+		errs = append(errs, err.(Errors)...)
+	}
+	if err := flat.validate(); err != nil {
+		errs = append(errs, err.(Errors)...)
+	}
+	return errs.err()
+}
+
+func (this *Pipeline) validate() error {
+	errs := Errors{}
+
+	type position struct {
+		job   *Job
+		stage int
+	}
+	jobs := map[string]position{}
+	// Names of the jobs parallel creates, pointing back at the job they
+	// came from.
+	instances := map[string]position{}
+
+	for i, stage := range this.Stages {
+		for _, job := range stage.Jobs {
+			if _, ok := jobs[job.Name]; ok {
+				errs.add(this, stage, job, ErrDuplicateJob)
+				continue
+			}
+			jobs[job.Name] = position{job, i}
+
+			expanded := job.ExpandParallel()
+			if len(expanded) > MaxParallel {
+				errs.add(this, stage, job, ErrParallel)
+			}
+			for _, instance := range expanded {
+				instances[instance.Name] = position{job, i}
+			}
+		}
+	}
+	lookup := func(name string) (position, bool) {
+		if target, ok := jobs[name]; ok {
+			return target, true
+		}
+		target, ok := instances[name]
+		return target, ok
+	}
+
+	for i, stage := range this.Stages {
+		for _, job := range stage.Jobs {
+			for _, need := range job.allNeeds() {
+				if need.Project != "" || need.Pipeline != "" {
+					continue
+				}
+				target, ok := lookup(need.Job)
+				if !ok {
+					if !need.Optional {
+						errs.add(this, stage, job, fmt.Errorf("needs %s: %w", need.Job, ErrUnknownJob))
+					}
+					continue
+				}
+				if need.Parallel != nil {
+					for _, instance := range need.Parallel.Expand(need.Job) {
+						if other, ok := instances[instance.Name]; !ok || other.job != target.job {
+							errs.add(this, stage, job, fmt.Errorf("needs %s: %w", instance.Name, ErrUnknownJob))
+						}
+					}
+				}
+				if target.stage > i {
+					errs.add(this, stage, job, fmt.Errorf("needs %s in stage %s: %w", need.Job, this.Stages[target.stage].Name, ErrStageOrder))
+				}
+			}
+
+			if job.Release != nil {
+				for _, err := range job.Release.validate(job) {
+					errs.add(this, stage, job, err)
+				}
+			}
+
+			if onStop := job.Environment.OnStop; onStop != "" {
+				if _, ok := lookup(onStop); !ok {
+					errs.add(this, stage, job, fmt.Errorf("environment on_stop %s: %w", onStop, ErrUnknownJob))
+				}
+			}
+
+			for _, dependency := range job.Dependencies {
+				target, ok := lookup(dependency)
+				if !ok {
+					errs.add(this, stage, job, fmt.Errorf("dependencies %s: %w", dependency, ErrUnknownJob))
+					continue
+				}
+				if target.stage >= i {
+					errs.add(this, stage, job, fmt.Errorf("dependencies %s in stage %s: %w", dependency, this.Stages[target.stage].Name, ErrStageOrder))
+				}
+			}
+		}
+	}
+
+	// Depth first search over needs, a job seen again while it is still on
+	// the path closes a cycle.
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := map[string]int{}
+	path := []string{}
+
+	var visit func(job *Job)
+	visit = func(job *Job) {
+		state[job.Name] = visiting
+		path = append(path, job.Name)
+
+		for _, need := range job.allNeeds() {
+			target, ok := lookup(need.Job)
+			if !ok || need.Project != "" || need.Pipeline != "" {
+				continue
+			}
+			switch state[target.job.Name] {
+			case unvisited:
+				visit(target.job)
+			case visiting:
+				start := 0
+				for path[start] != target.job.Name {
+					start++
+				}
+				cycle := append(append([]string{}, path[start:]...), target.job.Name)
+				errs.add(this, this.Stages[jobs[job.Name].stage], job, fmt.Errorf("%w: %s", ErrCyclicNeeds, strings.Join(cycle, " -> ")))
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[job.Name] = done
+	}
+
+	for _, stage := range this.Stages {
+		for _, job := range stage.Jobs {
+			if state[job.Name] == unvisited {
+				visit(job)
+			}
+		}
+	}
+
+	return errs.err()
+}
+
+// Validate checks every pipeline in the workflow.
+func (this *Workflow) Validate() error {
+	errs := Errors{}
+	for _, pipeline := range this.Pipelines {
+		if err := pipeline.Validate(); err != nil {
+			errs = append(errs, err.(Errors)...)
+		}
+	}
+	return errs.err()
+}
@@ -0,0 +1,136 @@
+package pipeline
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr []error
+	}{
+		{
+			name: "valid",
+			yaml: `
+stages: [build, test, deploy]
+build:
+  stage: build
+  script: [make]
+test:
+  stage: test
+  needs: [build]
+  dependencies: [build]
+  script: [make test]
+deploy:
+  stage: deploy
+  needs:
+    - test
+    - job: other
+      optional: true
+    - project: group/project
+      job: build
+      ref: main
+  environment:
+    name: production
+    on_stop: stop
+  script: [make deploy]
+stop:
+  stage: deploy
+  environment:
+    name: production
+    action: stop
+  script: [make stop]
+  when: manual
+`,
+		},
+		{
+			name:    "unknown need",
+			yaml:    "job:\n  needs: [missing]\n  script: [a]\n",
+			wantErr: []error{ErrUnknownJob},
+		},
+		{
+			name:    "unknown dependency",
+			yaml:    "job:\n  dependencies: [missing]\n  script: [a]\n",
+			wantErr: []error{ErrUnknownJob},
+		},
+		{
+			name: "need in a later stage",
+			yaml: `
+build:
+  stage: build
+  needs: [test]
+  script: [a]
+test:
+  stage: test
+  script: [a]
+`,
+			wantErr: []error{ErrStageOrder},
+		},
+		{
+			name: "dependency in the same stage",
+			yaml: `
+a:
+  stage: test
+  script: [a]
+b:
+  stage: test
+  dependencies: [a]
+  script: [a]
+`,
+			wantErr: []error{ErrStageOrder},
+		},
+		{
+			name: "needs cycle",
+			yaml: `
+a:
+  needs: [b]
+  script: [a]
+b:
+  needs: [a]
+  script: [a]
+`,
+			wantErr: []error{ErrCyclicNeeds},
+		},
+		{
+			name:    "unknown parent",
+			yaml:    "job:\n  extends: .missing\n  script: [a]\n",
+			wantErr: []error{ErrUnknownParent},
+		},
+		{
+			name: "needs after extends",
+			yaml: `
+.base:
+  needs: [missing]
+job:
+  extends: .base
+  script: [a]
+`,
+			wantErr: []error{ErrUnknownJob},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := Parse([]byte(test.yaml))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = p.Validate()
+			errs := Errors{}
+			if err != nil && !errors.As(err, &errs) {
+				t.Fatalf("err = %v, want Errors", err)
+			}
+			if len(errs) != len(test.wantErr) {
+				t.Fatalf("got %d errors, want %d: %v", len(errs), len(test.wantErr), err)
+			}
+			for i, want := range test.wantErr {
+				if !errors.Is(errs[i], want) {
+					t.Errorf("error %d = %v, want %v", i, errs[i], want)
+				}
+			}
+		})
+	}
+}
@@ -1,6 +1,16 @@
 package pipeline
 
-import "github.com/google/uuid"
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+// The name of the parent pipeline file written by WriteTo.
+const ParentFile = ".gitlab-ci.yml"
 
 type (
 	Workflow struct {
@@ -10,6 +20,12 @@ type (
 		Variables        map[string]any  `yaml:",omitempty"`
 		GenerateImage    string
 		GenerateCommands []string
+		// Where the generate job writes the child pipelines, a clean path
+		// below the project directory. It is used for the generate
+		// job's artifacts and the trigger jobs' includes.
+		OutputDir     string
+		id            string
+		deterministic bool
 	}
 )
 
@@ -21,7 +37,76 @@ func NewWorkflow() *Workflow {
 			"go run test.go",
 		},
 		GenerateImage: "ubuntu:latest",
+		OutputDir:     "output",
+	}
+}
+
+// SetID fixes the ID Render uses for DYNAMIC_JOB_ID, instead of a new random
+// one on every call.
+func (this *Workflow) SetID(id string) {
+	this.id = id
+}
+
+// SetDeterministic makes Render derive DYNAMIC_JOB_ID from the rendered parent
+// and child pipelines, and renders every child pipeline deterministically too,
+// so the same workflow always renders to the same bytes.
+func (this *Workflow) SetDeterministic(deterministic bool) {
+	this.deterministic = deterministic
+}
+
+func (this *Workflow) SetOutputDir(dir string) {
+	this.OutputDir = dir
+}
+
+// childFile is the path of a child pipeline as seen by the generate and
+// trigger jobs.
+func (this *Workflow) childFile(pipeline *Pipeline) string {
+	return path.Join(filepath.ToSlash(this.OutputDir), pipeline.Name+".yml")
+}
+
+// Files renders the parent pipeline and every child pipeline, keyed by the
+// path each one is written to. All problems found are returned as Errors.
+func (this *Workflow) Files() (map[string]string, error) {
+	files := map[string]string{}
+	errs := Errors{}
+
+	parent, children, err := this.render()
+	if err != nil {
+		errs = append(errs, err.(Errors)...)
 	}
+	files[path.Join(filepath.ToSlash(this.OutputDir), ParentFile)] = parent
+
+	for i, pipeline := range this.Pipelines {
+		files[this.childFile(pipeline)] = children[i]
+	}
+
+	return files, errs.err()
+}
+
+// WriteTo sets the output directory to dir and writes the parent pipeline as
+// dir/.gitlab-ci.yml and every child pipeline as dir/<name>.yml, so the
+// generate and trigger jobs always point at the files that were written. dir
+// must be a directory below the project directory, relative and without ..
+// or anything else path.Clean would remove. Nothing is written when
+// rendering fails.
+func (this *Workflow) WriteTo(dir string) error {
+	this.SetOutputDir(dir)
+
+	files, err := this.Files()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for name, content := range files {
+		log.Debug("Writing: " + name)
+		if err := os.WriteFile(filepath.FromSlash(name), []byte(content), 0660); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (this *Workflow) SetGenerateImage(name string) {
@@ -47,35 +132,68 @@ func (this *Workflow) AddVariable(variable string, value any) {
 	this.Variables[variable] = value
 }
 
-func (this *Workflow) Render() (out string) {
-	id := uuid.NewString()
-	this.ID = id
-	out += "##################################################################\n"
-	out += "# Dynamic Job ID: " + id + "\n"
-	out += "##################################################################\n"
-	out += "\n"
-
-	if len(this.Default.Tags) > 0 {
-		out += "# Default\n"
-		out += Marshal("default", this.Default)
-		out += "\n"
+// Render calls log.Fatal on any error, use RenderE to handle them instead.
+func (this *Workflow) Render() string {
+	out, err := this.RenderE()
+	if err != nil {
+		log.Fatal(err)
 	}
+	return out
+}
 
-	this.AddVariable("DYNAMIC_JOB_ID", id)
+// RenderE renders the parent pipeline and checks every child pipeline,
+// collecting all problems found into Errors.
+func (this *Workflow) RenderE() (string, error) {
+	out, _, err := this.render()
+	return out, err
+}
 
-	if len(this.Variables) > 0 {
-		out += "# Variables\n"
-		out += Marshal("variables", this.Variables)
-		out += "\n"
+// validOutputDir reports whether dir is a directory inside the project, which
+// artifacts:paths and include:local need. The project directory itself would
+// have the parent pipeline overwrite the project's own .gitlab-ci.yml.
+func validOutputDir(dir string) bool {
+	slashed := filepath.ToSlash(dir)
+	return filepath.IsLocal(dir) && path.Clean(slashed) == slashed && slashed != "."
+}
+
+// render returns the parent pipeline and the child pipelines in the order of
+// Pipelines.
+func (this *Workflow) render() (out string, children []string, err error) {
+	errs := Errors{}
+	if !validOutputDir(this.OutputDir) {
+		errs.add(nil, nil, nil, fmt.Errorf("%w: %q", ErrOutputDir, this.OutputDir))
+	}
+	marshal := func(pipeline *Pipeline, key string, o any) string {
+		out, err := MarshalE(key, o)
+		if err != nil {
+			errs.add(pipeline, nil, nil, err)
+		}
+		return out
 	}
 
 	artifacts := []string{}
+	children = []string{}
 	stages := []string{"generate"}
 	stageMap := map[string]bool{}
 
 	// Add Pipeline Stages
+	pipelineNames := map[string]bool{}
 	for _, pipeline := range this.Pipelines {
-		artifacts = append(artifacts, "output/"+pipeline.Name+".yml")
+		if pipelineNames[pipeline.Name] {
+			errs.add(pipeline, nil, nil, ErrDuplicatePipeline)
+		}
+		pipelineNames[pipeline.Name] = true
+		if pipeline.Name+".yml" == ParentFile {
+			errs.add(pipeline, nil, nil, ErrReservedPipeline)
+		}
+
+		child, err := pipeline.render(this.deterministic || pipeline.deterministic)
+		if err != nil {
+			errs = append(errs, err.(Errors)...)
+		}
+		children = append(children, child)
+
+		artifacts = append(artifacts, this.childFile(pipeline))
 		if _, ok := stageMap[pipeline.triggerStage]; !ok {
 			stages = append(stages, pipeline.triggerStage)
 			stageMap[pipeline.triggerStage] = true
@@ -83,7 +201,7 @@ func (this *Workflow) Render() (out string) {
 	}
 
 	out += "# Stages\n"
-	out += Marshal("stages", stages)
+	out += marshal(nil, "stages", stages)
 	out += "\n"
 
 	def := &Job{
@@ -97,7 +215,7 @@ func (this *Workflow) Render() (out string) {
 	}
 
 	out += "# Generate Jobs Here!\n"
-	out += Marshal("generate", def)
+	out += marshal(nil, "generate", def)
 	out += "\n"
 
 	// Add Pipeline Jobs
@@ -112,7 +230,7 @@ func (this *Workflow) Render() (out string) {
 				Strategy: "depend",
 				Include: []JobTriggerInclude{
 					{
-						Artifact: "output/" + pipeline.Name + ".yml",
+						Artifact: this.childFile(pipeline),
 						Job:      "generate",
 					},
 				},
@@ -121,9 +239,45 @@ func (this *Workflow) Render() (out string) {
 
 		name := "Trigger " + pipeline.Name
 		out += "# Trigger " + pipeline.Name + "\n"
-		out += Marshal(name, def)
+		out += marshal(pipeline, name, def)
 		out += "\n"
 	}
 
-	return
+	head := ""
+	if len(this.Default.Tags) > 0 {
+		head += "# Default\n"
+		head += marshal(nil, "default", this.Default)
+		head += "\n"
+	}
+
+	variables := map[string]any{}
+	for name, value := range this.Variables {
+		if name != "DYNAMIC_JOB_ID" {
+			variables[name] = value
+		}
+	}
+
+	id := this.id
+	switch {
+	case this.deterministic:
+		vars, _ := MarshalE("variables", variables)
+		id = contentID(append([]string{head, vars, out}, children...)...)
+	case id == "":
+		id = uuid.NewString()
+	}
+	this.ID = id
+	this.AddVariable("DYNAMIC_JOB_ID", id)
+
+	if len(this.Variables) > 0 {
+		head += "# Variables\n"
+		head += marshal(nil, "variables", this.Variables)
+		head += "\n"
+	}
+
+	header := "##################################################################\n"
+	header += "# Dynamic Job ID: " + id + "\n"
+	header += "##################################################################\n"
+	header += "\n"
+
+	return header + head + out, children, errs.err()
 }
@@ -0,0 +1,100 @@
+package pipeline
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestWorkflowFiles(t *testing.T) {
+	workflow := NewWorkflow()
+	workflow.SetID("id")
+	workflow.SetOutputDir("ci/generated")
+	for _, name := range []string{"build", "deploy"} {
+		pipeline := workflow.CreatePipeline(name)
+		pipeline.SetID(name)
+		pipeline.Stage("test").Job("job").AddCommand("true")
+	}
+
+	files, err := workflow.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	want := []string{"ci/generated/.gitlab-ci.yml", "ci/generated/build.yml", "ci/generated/deploy.yml"}
+	if !equalStrings(names, want) {
+		t.Fatalf("files = %q, want %q", names, want)
+	}
+
+	parent := files["ci/generated/.gitlab-ci.yml"]
+	for _, child := range want[1:] {
+		if !strings.Contains(parent, "artifact: "+child) {
+			t.Errorf("parent does not trigger %s:\n%s", child, parent)
+		}
+	}
+	if !strings.Contains(files["ci/generated/build.yml"], "# build (build)") {
+		t.Errorf("build.yml is not the build pipeline:\n%s", files["ci/generated/build.yml"])
+	}
+}
+
+func TestWorkflowErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		outputDir string
+		pipelines []string
+		wantErr   error
+	}{
+		{"empty output dir", "", []string{"build"}, ErrOutputDir},
+		{"project dir", ".", []string{"build"}, ErrOutputDir},
+		{"absolute output dir", "/tmp/ci", []string{"build"}, ErrOutputDir},
+		{"output dir outside", "../ci", []string{"build"}, ErrOutputDir},
+		{"unclean output dir", "ci/../output", []string{"build"}, ErrOutputDir},
+		{"trailing slash", "output/", []string{"build"}, ErrOutputDir},
+		{"duplicate pipeline", "output", []string{"build", "build"}, ErrDuplicatePipeline},
+		{"reserved pipeline", "output", []string{".gitlab-ci"}, ErrReservedPipeline},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			workflow := NewWorkflow()
+			workflow.SetOutputDir(test.outputDir)
+			for _, name := range test.pipelines {
+				workflow.CreatePipeline(name).Stage("test").Job("job").AddCommand("true")
+			}
+
+			if _, err := workflow.Files(); !errors.Is(err, test.wantErr) {
+				t.Errorf("Files() err = %v, want %v", err, test.wantErr)
+			}
+			if err := workflow.WriteTo(test.outputDir); !errors.Is(err, test.wantErr) {
+				t.Errorf("WriteTo() err = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestWorkflowDeterministic(t *testing.T) {
+	render := func() string {
+		workflow := NewWorkflow()
+		workflow.SetDeterministic(true)
+		workflow.CreatePipeline("build").Stage("test").Job("job").AddCommand("true")
+		// Appended directly, after SetDeterministic.
+		deploy := NewPipeline("deploy")
+		deploy.Stage("deploy").Job("job").AddCommand("true")
+		workflow.Pipelines = append(workflow.Pipelines, deploy)
+		files, err := workflow.Files()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return files["output/.gitlab-ci.yml"] + files["output/build.yml"] + files["output/deploy.yml"]
+	}
+
+	if a, b := render(), render(); a != b {
+		t.Errorf("renders differ:\n%s\n---\n%s", a, b)
+	}
+}
@@ -6,7 +6,6 @@ import (
 
 	"github.com/reflexias/gitlab-tools/pkg/getfile"
 	"github.com/reflexias/gitlab-tools/pkg/pipeline"
-	"gopkg.in/yaml.v3"
 )
 
 type (
@@ -17,15 +16,12 @@ type (
 )
 
 func main() {
-	// Get a file
-	rawData, err := getfile.GitFile("", os.Getenv("GITLAB_TOKEN"), "booleansnailfish/vital-ci-example", "data.yaml", "")
+	// Get and decode a file
+	fetcher, err := getfile.NewFetcher(getfile.WithToken(os.Getenv("GITLAB_TOKEN"), getfile.PrivateToken))
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	// Unmarshal the file
-	data := &Data{}
-	err = yaml.Unmarshal(rawData, data)
+	data, err := getfile.Decode[*Data](fetcher, "booleansnailfish/vital-ci-example", "data.yaml", "")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -97,19 +93,10 @@ func main() {
 		smokeJob.SetEnvironment(environment, "verify", "", "")
 	}
 
-	// Output the main pipeline
-	log.Println("Writing: pipeline.yml")
-	err = os.WriteFile("output/.gitlab-ci.yml", []byte(workflow.Render()), 0660)
+	// Output the main pipeline and the child pipelines
+	log.Println("Writing: output/")
+	err = workflow.WriteTo("output")
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	// Output the Child Pipelines
-	for _, pipeline := range workflow.Pipelines {
-		log.Println("Writing:", pipeline.Name+".yml")
-		err := os.WriteFile("output/"+pipeline.Name+".yml", []byte(pipeline.Render()), 0660)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
 }